@@ -0,0 +1,51 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+// touch records that an item was handed out or given back.
+func (p *Pool) touch() {
+	p.lastUsed.Store(time.Now().UnixNano())
+}
+
+// autoClose closes the pool once no item has been checked out for idleFor, and
+// calls onAutoClose if not nil. It stops when the pool is closed.
+func (p *Pool) autoClose(idleFor time.Duration, onAutoClose func()) {
+	timer := time.NewTimer(idleFor)
+	defer timer.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-timer.C:
+		}
+		wait := idleFor
+		if p.ActiveNum() == 0 {
+			wait -= time.Since(time.Unix(0, p.lastUsed.Load()))
+		}
+		if wait > 0 {
+			timer.Reset(wait)
+			continue
+		}
+		if p.IsClosed() {
+			return
+		}
+		_ = p.Close()
+		if onAutoClose != nil {
+			onAutoClose()
+		}
+		return
+	}
+}
+
+// closeOnDone closes the pool when ctx is done. It stops when the pool is
+// closed.
+func (p *Pool) closeOnDone(ctx context.Context) {
+	select {
+	case <-p.done:
+	case <-ctx.Done():
+		_ = p.Close()
+	}
+}
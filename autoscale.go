@@ -0,0 +1,21 @@
+package pool
+
+import "time"
+
+// StartAutoscaler starts a background goroutine which calls policy with the
+// pool stats every interval and resizes maxActive to the returned value. maxIdle
+// is kept at its value when the autoscaler starts, capped by the new maxActive,
+// so that it comes back when maxActive grows again. A non-positive or unchanged
+// value leaves the pool as is. Calling it again replaces the running
+// autoscaler. The autoscaler stops when the pool is closed.
+func (p *Pool) StartAutoscaler(interval time.Duration, policy func(stats Stats) (newMaxActive int)) {
+	p.lock.RLock()
+	maxIdle := p.maxIdle
+	p.lock.RUnlock()
+	p.runEvery(&p.stopAutoscaler, interval, func() {
+		stats := p.Stats()
+		if n := policy(stats); n > 0 && n != stats.MaxActive {
+			_ = p.Resize(n, maxIdle)
+		}
+	})
+}
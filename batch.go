@@ -0,0 +1,64 @@
+package pool
+
+import (
+	"context"
+	"io"
+)
+
+// GetBatch return n items from the pool. The active slots of the whole batch
+// are reserved at once, so that batch callers cannot deadlock each other by
+// holding part of their items. Either all n items are returned, or none and an
+// error. ErrBatchTooLarge is returned if the batch can never fit in the pool.
+func (p *Pool) GetBatch(ctx context.Context, n int) ([]io.Closer, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if n > p.active.cap() {
+		return nil, ErrBatchTooLarge
+	}
+	waited, err := p.acquire(ctx, n, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := make([]idleItem, 0, n)
+	created := make([]bool, 0, n)
+	fail := func(err error, slots int) ([]io.Closer, error) {
+		for _, it := range batch {
+			p.requeue(it)
+		}
+		p.releaseSlots(slots)
+		return nil, err
+	}
+	for len(batch) < n {
+		it, c, err := p.takeOrCreate(ctx)
+		if err != nil {
+			return fail(err, n)
+		}
+		batch = append(batch, it)
+		created = append(created, c)
+	}
+
+	extra := 0
+	for i := range batch {
+		batch[i].weight = p.weightOf(batch[i].item)
+		extra += batch[i].weight - 1
+	}
+	if extra > 0 && !p.active.tryAcquire(extra) {
+		if n+extra > p.active.cap() {
+			return fail(ErrBatchTooLarge, n)
+		}
+		// wait for all of them at once, like reserveWeight
+		p.releaseSlots(n)
+		if err := p.active.acquire(ctx, n+extra, 0); err != nil {
+			return fail(err, 0)
+		}
+	}
+
+	items := make([]io.Closer, len(batch))
+	for i, it := range batch {
+		p.handOut(it, waited, created[i])
+		items[i] = it.item
+	}
+	return items, nil
+}
@@ -0,0 +1,104 @@
+package pool
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrFactoryBackoff is returned instead of calling the factory while it is
+// backed off after repeated failures. See WithFactoryBackoff.
+var ErrFactoryBackoff = errors.New("pool factory is backed off")
+
+// BreakerState is the state of the factory backoff.
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // the factory is called
+	BreakerOpen                         // the factory is backed off
+	BreakerHalfOpen                     // one call probes whether the factory recovered
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// breaker stops calling the factory for a while after too many consecutive
+// failures. The zero value never trips.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	state     BreakerState
+	until     time.Time
+}
+
+// allow reports whether the factory may be called. Once the cooldown is over,
+// a single call is let through to probe the factory.
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.until) {
+			return ErrFactoryBackoff
+		}
+		b.state = BreakerHalfOpen
+	case BreakerHalfOpen:
+		// a probe is in flight
+		return ErrFactoryBackoff
+	}
+	return nil
+}
+
+// done records the result of a factory call allowed by allow.
+func (b *breaker) done(ok bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ok {
+		b.failures = 0
+		b.state = BreakerClosed
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		// between half and one and a half cooldown, so that pools sharing a
+		// backend do not probe it all at once
+		jitter := time.Duration(rand.Int63n(int64(b.cooldown) + 1))
+		b.state = BreakerOpen
+		b.until = time.Now().Add(b.cooldown/2 + jitter)
+	}
+}
+
+// abort records a factory call which failed because of the caller context. It
+// does not count as a failure, and the next call probes the factory again.
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerHalfOpen {
+		// the cooldown is already over
+		b.state = BreakerOpen
+	}
+}
+
+func (b *breaker) current() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && !time.Now().Before(b.until) {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
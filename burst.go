@@ -0,0 +1,59 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// burst counts the items checked out over maxActive. A burst starts when the
+// first extra item is taken, and may grow for window. The zero value allows no
+// burst.
+type burst struct {
+	mu     sync.Mutex
+	extra  int
+	window time.Duration
+	used   int
+	since  time.Time
+}
+
+// take reserves n extra slots if the burst allows it.
+func (b *burst) take(n int) bool {
+	if b.extra <= 0 {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.extra {
+		return false
+	}
+	if b.used == 0 {
+		b.since = time.Now()
+	} else if time.Since(b.since) > b.window {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// give gives back up to n extra slots and return the remaining slots, which
+// belong to the semaphore.
+func (b *burst) give(n int) int {
+	if b.extra <= 0 {
+		return n
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	k := min(n, b.used)
+	b.used -= k
+	return n - k
+}
+
+// len return the extra slots in use.
+func (b *burst) len() int {
+	if b.extra <= 0 {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
@@ -0,0 +1,39 @@
+package pool
+
+import (
+	"io"
+	"sync"
+)
+
+// CloserFunc adapts f to io.Closer. f is called only by the first Close, later
+// calls return the same error.
+func CloserFunc(f func() error) io.Closer {
+	return &funcCloser{f: f}
+}
+
+type funcCloser struct {
+	once sync.Once
+	f    func() error
+	err  error
+}
+
+func (c *funcCloser) Close() error {
+	c.once.Do(func() { c.err = c.f() })
+	return c.err
+}
+
+// Nop holds a value which does not need to be closed, so that it can be kept in
+// a pool. Close does nothing.
+type Nop[T any] struct {
+	Value T
+}
+
+// NopCloser wraps v in a Nop.
+func NopCloser[T any](v T) *Nop[T] {
+	return &Nop[T]{Value: v}
+}
+
+// Close does nothing and return nil.
+func (*Nop[T]) Close() error {
+	return nil
+}
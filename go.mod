@@ -0,0 +1,3 @@
+module github.com/wcy16/pool
+
+go 1.22
@@ -0,0 +1,138 @@
+package pool
+
+import (
+	"container/heap"
+	"io"
+	"sort"
+	"time"
+)
+
+// heapStore hands out the item closest to its deadline first, so that items
+// are used before they expire, and expired items are evicted without scanning
+// the whole pool. The deadline of an item is computed when it is pushed. With
+// order set, items are handed out by the time it gives instead.
+type heapStore struct {
+	h        expiryHeap
+	deadline func(idleItem) time.Time
+	order    func(idleItem) time.Time // nil to order by deadline
+}
+
+type expiryEntry struct {
+	it idleItem
+	at time.Time // zero if the item never expires
+}
+
+func (s *heapStore) push(it idleItem) {
+	at := s.deadline
+	if s.order != nil {
+		at = s.order
+	}
+	heap.Push(&s.h, expiryEntry{it: it, at: at(it)})
+}
+
+func (s *heapStore) pop() (idleItem, bool) {
+	if len(s.h) == 0 {
+		return idleItem{}, false
+	}
+	return heap.Pop(&s.h).(expiryEntry).it, true
+}
+
+func (s *heapStore) len() int {
+	return len(s.h)
+}
+
+func (s *heapStore) remove(item io.Closer) (idleItem, bool) {
+	for i, e := range s.h {
+		if e.it.item == item {
+			heap.Remove(&s.h, i)
+			return e.it, true
+		}
+	}
+	return idleItem{}, false
+}
+
+func (s *heapStore) trim(n int) []idleItem {
+	trimmed := make([]idleItem, 0, n)
+	for ; n > 0; n-- {
+		trimmed = append(trimmed, heap.Pop(&s.h).(expiryEntry).it)
+	}
+	return trimmed
+}
+
+func (s *heapStore) shrink(n int) []idleItem {
+	sort.Sort(s.h) // a sorted slice is a valid heap
+	size := len(s.h) - n
+	evicted := make([]idleItem, 0, n)
+	for _, e := range s.h[size:] {
+		evicted = append(evicted, e.it)
+	}
+	clear(s.h[size:])
+	s.h = s.h[:size]
+	return evicted
+}
+
+func (s *heapStore) drain() []idleItem {
+	items := s.snapshot()
+	s.h = nil
+	return items
+}
+
+func (s *heapStore) evictExpired(now time.Time) []idleItem {
+	var evicted []idleItem
+	if s.order != nil {
+		// not ordered by deadline, scan them all
+		kept := s.h[:0]
+		for _, e := range s.h {
+			if d := s.deadline(e.it); !d.IsZero() && now.After(d) {
+				evicted = append(evicted, e.it)
+			} else {
+				kept = append(kept, e)
+			}
+		}
+		clear(s.h[len(kept):])
+		s.h = kept
+		heap.Init(&s.h)
+		return evicted
+	}
+	for len(s.h) > 0 && !s.h[0].at.IsZero() && now.After(s.h[0].at) {
+		evicted = append(evicted, heap.Pop(&s.h).(expiryEntry).it)
+	}
+	return evicted
+}
+
+func (s *heapStore) snapshot() []idleItem {
+	h := append(expiryHeap(nil), s.h...)
+	sort.Stable(h) // in the order they would be popped
+	items := make([]idleItem, len(h))
+	for i, e := range h {
+		items[i] = e.it
+	}
+	return items
+}
+
+// expiryHeap orders entries by time, the ones with a zero time last.
+type expiryHeap []expiryEntry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool {
+	if h[i].at.IsZero() || h[j].at.IsZero() {
+		return !h[i].at.IsZero()
+	}
+	return h[i].at.Before(h[j].at)
+}
+
+func (h expiryHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *expiryHeap) Push(x any) {
+	*h = append(*h, x.(expiryEntry))
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = expiryEntry{}
+	*h = old[:n-1]
+	return e
+}
@@ -0,0 +1,433 @@
+package pool
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// idleStore keeps the idle items in the order they are handed out. It is not
+// safe for concurrent use, idleList guards it.
+type idleStore interface {
+	// push adds a returned item.
+	push(it idleItem)
+	// pop removes the next item to hand out.
+	pop() (idleItem, bool)
+	len() int
+	// remove removes item if it is in the store.
+	remove(item io.Closer) (idleItem, bool)
+	// trim removes up to n items which were idle the longest.
+	trim(n int) []idleItem
+	// shrink removes n items, the ones which would be handed out last.
+	shrink(n int) []idleItem
+	// drain removes all items.
+	drain() []idleItem
+	// evictExpired removes the items past their deadline at now.
+	evictExpired(now time.Time) []idleItem
+	// snapshot return the items without removing them.
+	snapshot() []idleItem
+}
+
+// idleList holds the idle items of the pool up to size, in the order given by
+// its store.
+type idleList struct {
+	mu       sync.Mutex
+	s        idleStore
+	size     int
+	reserved int           // room taken by items about to be pushed with commit
+	closed   bool          // set by close, no item is accepted then
+	room     chan struct{} // closed when an item is taken from a full list
+	came     chan struct{} // closed when an item is pushed, nil if nobody waits
+
+	// idle items per key, only with WithKeyFunc
+	keyOf     func(io.Closer) string
+	maxPerKey int
+	keys      map[string]int
+
+	// the items in the list which are comparable, so that has does not
+	// scan the store
+	index map[io.Closer]struct{}
+
+	// length and capacity, readable without mu
+	count atomic.Int64
+	limit atomic.Int64
+}
+
+func newIdleList(size int, s idleStore) *idleList {
+	l := &idleList{s: s, size: size, room: make(chan struct{}), index: make(map[io.Closer]struct{})}
+	l.limit.Store(int64(size))
+	return l
+}
+
+// limitKeys keeps at most maxPerKey items with the same key in the list.
+func (l *idleList) limitKeys(keyOf func(io.Closer) string, maxPerKey int) {
+	l.keyOf = keyOf
+	l.maxPerKey = maxPerKey
+	l.keys = make(map[string]int)
+}
+
+// push adds an item to the list, it return false if the list is full or holds
+// too many items with the same key.
+func (l *idleList) push(it idleItem) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.take(&it) {
+		return false
+	}
+	l.insert(it)
+	return true
+}
+
+// reserve takes room for it, which is then pushed with commit or given up with
+// cancel, so that the caller can prepare the item knowing it will be kept. It
+// return false if the list is full, and whether waiting for room may help,
+// which it does not if the list has size 0 or too many items with the key.
+func (l *idleList) reserve(it *idleItem) (ok, retry bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.take(it) {
+		return false, !l.closed && l.size > 0 && l.full()
+	}
+	l.reserved++
+	return true, false
+}
+
+// commit pushes an item for which room was reserved. It only fails if the list
+// was resized below the reserved room or closed meanwhile, in which case the
+// item is not pushed.
+func (l *idleList) commit(it idleItem) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reserved--
+	if l.closed || l.s.len() >= l.size {
+		l.forget(it)
+		return false
+	}
+	l.insert(it)
+	return true
+}
+
+// cancel gives up the room reserved for it.
+func (l *idleList) cancel(it idleItem) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := l.full()
+	l.reserved--
+	l.forget(it)
+	if full {
+		l.notify()
+	}
+}
+
+// take accounts a new item if there is room for it, must be called with mu
+// held.
+func (l *idleList) take(it *idleItem) bool {
+	if l.closed || l.full() {
+		return false
+	}
+	if l.keyOf != nil {
+		it.key = l.keyOf(it.item)
+		if l.keys[it.key] >= l.maxPerKey {
+			return false
+		}
+		l.keys[it.key]++
+	}
+	return true
+}
+
+// full reports whether there is no room left, must be called with mu held.
+func (l *idleList) full() bool {
+	return l.s.len()+l.reserved >= l.size
+}
+
+// insert adds an accounted item to the store, must be called with mu held.
+func (l *idleList) insert(it idleItem) {
+	if identifiable(it.item) {
+		l.index[it.item] = struct{}{}
+	}
+	l.s.push(it)
+	l.update()
+	if l.came != nil {
+		close(l.came)
+		l.came = nil
+	}
+}
+
+// pop takes the next item from the list.
+func (l *idleList) pop() (idleItem, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := l.full()
+	it, ok := l.s.pop()
+	if ok {
+		l.forget(it)
+	}
+	l.update()
+	if ok && full {
+		l.notify()
+	}
+	return it, ok
+}
+
+// has reports whether item is in the list.
+func (l *idleList) has(item io.Closer) bool {
+	if !identifiable(item) {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.index[item]
+	return ok
+}
+
+// remove takes item from the list if it is still there.
+func (l *idleList) remove(item io.Closer) (idleItem, bool) {
+	if !identifiable(item) {
+		return idleItem{}, false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.index[item]; !ok {
+		return idleItem{}, false
+	}
+	full := l.full()
+	it, ok := l.s.remove(item)
+	if ok {
+		l.forget(it)
+	}
+	l.update()
+	if ok && full {
+		l.notify()
+	}
+	return it, ok
+}
+
+// len return the number of items. It does not wait for mu.
+func (l *idleList) len() int {
+	return int(l.count.Load())
+}
+
+// cap return the size of the list. It does not wait for mu.
+func (l *idleList) cap() int {
+	return int(l.limit.Load())
+}
+
+// forget drops the index entries and keys of items removed from the store and
+// return them, must be called with mu held.
+func (l *idleList) forget(items ...idleItem) []idleItem {
+	for _, it := range items {
+		if identifiable(it.item) {
+			delete(l.index, it.item)
+		}
+		if l.keyOf == nil {
+			continue
+		}
+		if l.keys[it.key]--; l.keys[it.key] <= 0 {
+			delete(l.keys, it.key)
+		}
+	}
+	return items
+}
+
+// update refreshes the length after the store changed, must be called with mu
+// held.
+func (l *idleList) update() {
+	l.count.Store(int64(l.s.len()))
+}
+
+// trim removes up to n items which were idle the longest time.
+func (l *idleList) trim(n int) []idleItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n = min(n, l.s.len())
+	if n <= 0 {
+		return nil
+	}
+	if l.full() {
+		l.notify()
+	}
+	defer l.update()
+	return l.forget(l.s.trim(n)...)
+}
+
+// drain removes all items from the list and return them.
+func (l *idleList) drain() []idleItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	items := l.forget(l.s.drain()...)
+	l.update()
+	l.notify()
+	return items
+}
+
+// close makes the list refuse the items pushed from now on, and wakes up the
+// callers waiting for room. The items in the list are left to drain.
+func (l *idleList) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	l.notify()
+}
+
+// evictExpired removes the items past their deadline and return them.
+func (l *idleList) evictExpired(now time.Time) []idleItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	full := l.full()
+	evicted := l.forget(l.s.evictExpired(now)...)
+	l.update()
+	if len(evicted) > 0 && full {
+		l.notify()
+	}
+	return evicted
+}
+
+// snapshot return the items in the list without removing them.
+func (l *idleList) snapshot() []idleItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.s.snapshot()
+}
+
+// resize changes the size of the list and return the items over the new size.
+// The items which would be taken first are kept.
+func (l *idleList) resize(size int) []idleItem {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.size = size
+	l.limit.Store(int64(size))
+	l.notify()
+	n := l.s.len() - size
+	if n <= 0 {
+		return nil
+	}
+	defer l.update()
+	return l.forget(l.s.shrink(n)...)
+}
+
+// wait return a channel which is closed when there may be room in the list.
+func (l *idleList) wait() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.full() {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return l.room
+}
+
+// arrival return a channel which is closed when there may be an item in the
+// list.
+func (l *idleList) arrival() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.s.len() > 0 {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	if l.came == nil {
+		l.came = make(chan struct{})
+	}
+	return l.came
+}
+
+// notify wakes up the waiters for room, must be called with mu held.
+func (l *idleList) notify() {
+	close(l.room)
+	l.room = make(chan struct{})
+}
+
+// sliceStore hands out items in FIFO order, or in LIFO order so that the most
+// recently returned item is reused.
+type sliceStore struct {
+	items    []idleItem // oldest returned first
+	lifo     bool
+	deadline func(idleItem) time.Time
+}
+
+func (s *sliceStore) push(it idleItem) {
+	s.items = append(s.items, it)
+}
+
+func (s *sliceStore) pop() (idleItem, bool) {
+	n := len(s.items)
+	if n == 0 {
+		return idleItem{}, false
+	}
+	var it idleItem
+	if s.lifo {
+		it = s.items[n-1]
+		s.items[n-1] = idleItem{}
+		s.items = s.items[:n-1]
+	} else {
+		it = s.items[0]
+		s.items[0] = idleItem{}
+		s.items = s.items[1:]
+	}
+	return it, true
+}
+
+func (s *sliceStore) len() int {
+	return len(s.items)
+}
+
+func (s *sliceStore) remove(item io.Closer) (idleItem, bool) {
+	for i, it := range s.items {
+		if it.item == item {
+			n := copy(s.items[i:], s.items[i+1:])
+			s.items[i+n] = idleItem{}
+			s.items = s.items[:i+n]
+			return it, true
+		}
+	}
+	return idleItem{}, false
+}
+
+func (s *sliceStore) trim(n int) []idleItem {
+	trimmed := append([]idleItem(nil), s.items[:n]...)
+	rest := copy(s.items, s.items[n:])
+	clear(s.items[rest:])
+	s.items = s.items[:rest]
+	return trimmed
+}
+
+func (s *sliceStore) shrink(n int) []idleItem {
+	var evicted []idleItem
+	size := len(s.items) - n
+	if s.lifo {
+		evicted = append(evicted, s.items[:n]...)
+		s.items = append([]idleItem(nil), s.items[n:]...)
+	} else {
+		evicted = append(evicted, s.items[size:]...)
+		s.items = append([]idleItem(nil), s.items[:size]...)
+	}
+	return evicted
+}
+
+func (s *sliceStore) drain() []idleItem {
+	items := s.items
+	s.items = nil
+	return items
+}
+
+func (s *sliceStore) evictExpired(now time.Time) []idleItem {
+	var evicted []idleItem
+	kept := s.items[:0]
+	for _, it := range s.items {
+		if d := s.deadline(it); !d.IsZero() && now.After(d) {
+			evicted = append(evicted, it)
+		} else {
+			kept = append(kept, it)
+		}
+	}
+	clear(s.items[len(kept):])
+	s.items = kept
+	return evicted
+}
+
+func (s *sliceStore) snapshot() []idleItem {
+	return append([]idleItem(nil), s.items...)
+}
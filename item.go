@@ -0,0 +1,58 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+)
+
+// ErrItemReturned is returned when an Item is returned or discarded twice.
+var ErrItemReturned = errors.New("item already returned")
+
+// Item is a handle of an item checked out from a pool, so that it can be given
+// back without a reference to the pool.
+type Item struct {
+	Value io.Closer
+
+	p    *Pool
+	done atomic.Bool
+}
+
+// GetItem return a handle of an item from the pool. See Pool.Get.
+func (p *Pool) GetItem(ctx context.Context) (*Item, error) {
+	item, err := p.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Item{Value: item, p: p}, nil
+}
+
+// Return put back the item in the pool. If the pool is closed, the item is
+// closed and ErrPoolClosed is returned. Only the first call to Return, Close or
+// Discard has an effect, the next ones return ErrItemReturned.
+func (i *Item) Return() error {
+	if i.done.Swap(true) {
+		return ErrItemReturned
+	}
+	closed := i.p.IsClosed()
+	i.p.Put(i.Value)
+	if closed {
+		return ErrPoolClosed
+	}
+	return nil
+}
+
+// Close is the same as Return, so that an Item is an io.Closer.
+func (i *Item) Close() error {
+	return i.Return()
+}
+
+// Discard closes the item instead of putting it back in the pool, for example
+// when it is broken, and frees its slot. See Pool.Discard.
+func (i *Item) Discard() error {
+	if i.done.Swap(true) {
+		return ErrItemReturned
+	}
+	return i.p.Discard(i.Value)
+}
@@ -0,0 +1,80 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// metaStore holds the metadata of the items of a pool.
+type metaStore struct {
+	mu sync.Mutex
+	m  map[io.Closer]any
+}
+
+func (s *metaStore) get(item io.Closer) any {
+	if !identifiable(item) {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.m[item]
+}
+
+func (s *metaStore) set(item io.Closer, md any) {
+	if !identifiable(item) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if md == nil {
+		delete(s.m, item)
+		return
+	}
+	if s.m == nil {
+		s.m = make(map[io.Closer]any)
+	}
+	s.m[item] = md
+}
+
+// withMetaStore makes the pool keep its metadata in s, so that the factory
+// given to NewWithMetadata can fill it before the pool exists.
+func withMetaStore(s *metaStore) Option {
+	return func(o *options) {
+		o.meta = s
+	}
+}
+
+// NewWithMetadata is like NewContext, but the factory also return metadata of
+// the item, for example the backend address or a session token, which the
+// hooks can read with Pool.Metadata instead of deriving it again.
+func NewWithMetadata(factory func(ctx context.Context) (io.Closer, any, error), opts ...Option) (*Pool, error) {
+	meta := &metaStore{}
+	return NewContext(func(ctx context.Context) (io.Closer, error) {
+		item, md, err := factory(ctx)
+		if err == nil && item != nil {
+			meta.set(item, md)
+		}
+		return item, err
+	}, append(opts, withMetaStore(meta))...)
+}
+
+// Metadata return the metadata of an item of the pool, or nil if it has none.
+// The metadata is kept until the pool closes the item, including in the
+// OnClose hook, or the item leaves the pool with ReleaseItem, Discard or
+// Detach. Release does not know the item, so the metadata of an item given up
+// with it, or closed by the caller, stays until SetMetadata(item, nil).
+func (p *Pool) Metadata(item io.Closer) any {
+	return p.meta.get(item)
+}
+
+// SetMetadata sets the metadata of an item of the pool, a nil md removes it.
+// Items which are not comparable, like slices, cannot have metadata.
+func (p *Pool) SetMetadata(item io.Closer, md any) {
+	p.meta.set(item, md)
+}
+
+// Metadata return the metadata of the item. See Pool.Metadata.
+func (i *Item) Metadata() any {
+	return i.p.Metadata(i.Value)
+}
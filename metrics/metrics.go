@@ -0,0 +1,106 @@
+// Package metrics exports the stats of a pool as Prometheus metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wcy16/pool"
+)
+
+// Collector is a prometheus.Collector reading the stats of a pool on scrape.
+type Collector struct {
+	pool *pool.Pool
+
+	active       *prometheus.Desc
+	idle         *prometheus.Desc
+	maxActive    *prometheus.Desc
+	maxIdle      *prometheus.Desc
+	created      *prometheus.Desc
+	closed       *prometheus.Desc
+	gets         *prometheus.Desc
+	puts         *prometheus.Desc
+	releases     *prometheus.Desc
+	overflow     *prometheus.Desc
+	slowCloses   *prometheus.Desc
+	waitCount    *prometheus.Desc
+	waitDuration *prometheus.Desc
+	breaker      *prometheus.Desc
+	failureRate  *prometheus.Desc
+}
+
+// NewCollector create a collector for p. The metric names are prefixed with
+// namespace and subsystem, and constLabels are added to all of them.
+func NewCollector(p *pool.Pool, namespace, subsystem string, constLabels prometheus.Labels) *Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, name), help, nil, constLabels)
+	}
+	return &Collector{
+		pool:         p,
+		active:       desc("active", "Number of items checked out from the pool."),
+		idle:         desc("idle", "Number of idle items in the pool."),
+		maxActive:    desc("max_active", "Maximum number of items checked out at the same time."),
+		maxIdle:      desc("max_idle", "Maximum number of idle items in the pool."),
+		created:      desc("created_total", "Total number of items created by the factory."),
+		closed:       desc("closed_total", "Total number of items closed by the pool."),
+		gets:         desc("gets_total", "Total number of successful Get calls."),
+		puts:         desc("puts_total", "Total number of Put calls."),
+		releases:     desc("releases_total", "Total number of Release calls."),
+		overflow:     desc("overflow_closed_total", "Total number of items closed on Put because the pool was full."),
+		slowCloses:   desc("slow_closes_total", "Total number of items which did not close within the close timeout."),
+		waitCount:    desc("wait_count_total", "Total number of Get calls which waited for an active slot."),
+		waitDuration: desc("wait_duration_seconds_total", "Total time spent waiting for an active slot."),
+		breaker: prometheus.NewDesc(prometheus.BuildFQName(namespace, subsystem, "breaker_state"),
+			"State of the factory backoff, 1 for the current state.", []string{"state"}, constLabels),
+		failureRate: desc("creation_failure_rate", "Share of factory calls which failed over the failure window."),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.active
+	ch <- c.idle
+	ch <- c.maxActive
+	ch <- c.maxIdle
+	ch <- c.created
+	ch <- c.closed
+	ch <- c.gets
+	ch <- c.puts
+	ch <- c.releases
+	ch <- c.overflow
+	ch <- c.slowCloses
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.breaker
+	ch <- c.failureRate
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stats()
+	gauge := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, v)
+	}
+	counter := func(d *prometheus.Desc, v float64) {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.CounterValue, v)
+	}
+	gauge(c.active, float64(s.Active))
+	gauge(c.idle, float64(s.Idle))
+	gauge(c.maxActive, float64(s.MaxActive))
+	gauge(c.maxIdle, float64(s.MaxIdle))
+	counter(c.created, float64(s.Created))
+	counter(c.closed, float64(s.Closed))
+	counter(c.gets, float64(s.Gets))
+	counter(c.puts, float64(s.Puts))
+	counter(c.releases, float64(s.Releases))
+	counter(c.overflow, float64(s.OverflowClosed))
+	counter(c.slowCloses, float64(s.SlowCloses))
+	counter(c.waitCount, float64(s.WaitCount))
+	counter(c.waitDuration, s.WaitDuration.Seconds())
+	gauge(c.failureRate, s.CreationFailureRate)
+	for _, state := range []pool.BreakerState{pool.BreakerClosed, pool.BreakerOpen, pool.BreakerHalfOpen} {
+		v := 0.0
+		if s.Breaker == state {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(c.breaker, prometheus.GaugeValue, v, state.String())
+	}
+}
@@ -0,0 +1,422 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Option configures a pool created by NewWithOptions.
+type Option func(*options)
+
+type options struct {
+	maxActive     int
+	maxIdle       int
+	maxIdleSet    bool
+	minIdle       int
+	initialIdle   int
+	lowWater      int
+	maxCreating   int
+	maxWaiters    int
+	burst         int
+	burstWindow   time.Duration
+	keyOf         func(io.Closer) string
+	maxPerKey     int
+	backoffAfter  int
+	backoffFor    time.Duration
+	failureWindow time.Duration
+	lifo          bool
+	expiryOrder   bool
+	createdOrder  bool
+	maxIdleTime   time.Duration
+	maxLifetime   time.Duration
+	maxUsage      int
+	waitTimeout   time.Duration
+	closeTimeout  time.Duration
+	putGrace      time.Duration
+	keepOnError   bool
+	pauseWait     bool
+	ownerCheck    bool
+	retries       int
+	retryBackoff  time.Duration
+	autoClose     time.Duration
+	onAutoClose   func()
+	ctx           context.Context
+	weight        func(io.Closer) int
+	validate      func(context.Context, io.Closer) bool
+	healthCheck   func(io.Closer) bool
+	onCreate      func(io.Closer, time.Duration)
+	onClose       func(io.Closer, CloseReason)
+	onGet         func(io.Closer, time.Duration, bool)
+	onPut         func(io.Closer, bool)
+	onReturn      func(context.Context, io.Closer) error
+	onExhausted   func()
+	onAvailable   func()
+
+	leakThreshold time.Duration
+	meta          *metaStore
+}
+
+// WithMaxActive sets the maximum number of items checked out at the same time.
+func WithMaxActive(n int) Option {
+	return func(o *options) {
+		o.maxActive = n
+	}
+}
+
+// WithMaxIdle sets the maximum number of idle items kept in the pool. It
+// defaults to max active if not given.
+func WithMaxIdle(n int) Option {
+	return func(o *options) {
+		o.maxIdle = n
+		o.maxIdleSet = true
+	}
+}
+
+// WithMinIdle sets the number of idle items the reaper keeps in the pool. See
+// Pool.StartReaper.
+func WithMinIdle(n int) Option {
+	return func(o *options) {
+		o.minIdle = n
+	}
+}
+
+// WithInitialIdle makes the constructor create n idle items before returning,
+// like FillTo(n). If the factory fails, the items created so far are closed and
+// the constructor return the *FillError.
+func WithInitialIdle(n int) Option {
+	return func(o *options) {
+		o.initialIdle = n
+	}
+}
+
+// WithBurst lets up to extra items be checked out over maxActive instead of
+// waiting, for spiky traffic. A burst may grow for window after it starts, then
+// callers wait as usual. While items are checked out over maxActive, the items
+// put back are closed, until the pool is back under maxActive.
+func WithBurst(extra int, window time.Duration) Option {
+	return func(o *options) {
+		o.burst = extra
+		o.burstWindow = window
+	}
+}
+
+// WithMaxWaiters limits the number of Get calls waiting for an active slot. When
+// n callers already wait, Get fails right away with ErrTooManyWaiters, so that
+// an overloaded pool sheds load instead of growing its queue.
+func WithMaxWaiters(n int) Option {
+	return func(o *options) {
+		o.maxWaiters = n
+	}
+}
+
+// WithMaxCreating limits the number of factory calls made at the same time by
+// Get when the pool is empty. The other callers wait, and take an item put back
+// in the meantime if any.
+func WithMaxCreating(n int) Option {
+	return func(o *options) {
+		o.maxCreating = n
+	}
+}
+
+// WithKeyFunc keeps at most maxPerKey idle items with the same key, for example
+// the backend address, so that the idle items stay spread across keys. Items
+// put back over the limit are closed as if the pool was full.
+func WithKeyFunc(keyOf func(io.Closer) string, maxPerKey int) Option {
+	return func(o *options) {
+		o.keyOf = keyOf
+		o.maxPerKey = maxPerKey
+	}
+}
+
+// WithCoalesceCreation makes callers of an empty pool create items one at a
+// time, the others wait for that creation or for an item put back meanwhile,
+// whichever comes first. It is WithMaxCreating(1): a cold pool hit by many
+// callers opens few items and reuses them, at the cost of serializing the
+// factory, so a burst of callers waits longer than with concurrent creations
+// when items are held for long.
+func WithCoalesceCreation() Option {
+	return WithMaxCreating(1)
+}
+
+// WithRefill makes the pool create items in the background whenever a Get
+// leaves fewer than lowWater idle items, so that the next Get does not wait for
+// the factory. The pool is not refilled above maxIdle, nor above maxActive items
+// in total.
+func WithRefill(lowWater int) Option {
+	return func(o *options) {
+		o.lowWater = lowWater
+	}
+}
+
+// WithFactoryBackoff stops calling the factory after failures consecutive
+// failures. The factory is then backed off for about cooldown, with some jitter,
+// and creations fail with ErrFactoryBackoff. After that, a single creation
+// probes the factory, and the backoff ends if it succeeds or starts over
+// otherwise. Failures due to the caller context are not counted.
+func WithFactoryBackoff(failures int, cooldown time.Duration) Option {
+	return func(o *options) {
+		o.backoffAfter = failures
+		o.backoffFor = cooldown
+	}
+}
+
+// WithFailureWindow sets the sliding window of Pool.CreationFailureRate.
+func WithFailureWindow(window time.Duration) Option {
+	return func(o *options) {
+		o.failureWindow = window
+	}
+}
+
+// WithLIFO makes the pool hand out the most recently returned item first, so
+// that a small set of items is kept warm while the rest can expire. The pool is
+// FIFO by default.
+func WithLIFO() Option {
+	return func(o *options) {
+		o.lifo = true
+	}
+}
+
+// WithExpiryOrder makes the pool hand out the idle item closest to expire due
+// to MaxIdleTime or MaxLifetime first. The idle items are kept in a heap, so
+// the reaper finds the expired ones without scanning the whole pool. It takes
+// precedence over WithLIFO.
+func WithExpiryOrder() Option {
+	return func(o *options) {
+		o.expiryOrder = true
+	}
+}
+
+// WithCreationOrder makes the pool hand out the idle item created the longest
+// ago first, so that the item serving a request is predictable, for example in
+// tests of connection rotation. WithExpiryOrder takes precedence over it, and
+// it takes precedence over WithLIFO.
+func WithCreationOrder() Option {
+	return func(o *options) {
+		o.createdOrder = true
+	}
+}
+
+// WithMaxIdleTime sets the maximum amount of time an item may stay idle. See
+// Pool.SetMaxIdleTime.
+func WithMaxIdleTime(d time.Duration) Option {
+	return func(o *options) {
+		o.maxIdleTime = d
+	}
+}
+
+// WithMaxLifetime sets the maximum amount of time an item may be reused. See
+// Pool.SetMaxLifetime.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(o *options) {
+		o.maxLifetime = d
+	}
+}
+
+// WithMaxUsage sets how many times an item may be handed out. Items reaching
+// it are closed when they are put back. Zero means unlimited.
+func WithMaxUsage(n int) Option {
+	return func(o *options) {
+		o.maxUsage = n
+	}
+}
+
+// WithWaitTimeout bounds the time Get waits for an active slot, even if the
+// caller context has no deadline. An earlier caller deadline is kept.
+func WithWaitTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.waitTimeout = d
+	}
+}
+
+// WithGetRetries makes Get call the factory again up to n times, waiting backoff
+// in between, when it fails to create an item. The active slot is kept while
+// retrying. A done context stops the retries right away, and so does
+// ErrFactoryBackoff.
+func WithGetRetries(n int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retries = n
+		o.retryBackoff = backoff
+	}
+}
+
+// WithPutGrace makes Put wait up to d for room when the pool is full, instead of
+// closing the item right away, so that a short spike of returns does not churn
+// items. The pool lock is not held while waiting. See PutContext to wait longer.
+func WithPutGrace(d time.Duration) Option {
+	return func(o *options) {
+		o.putGrace = d
+	}
+}
+
+// WithKeepOnError makes Pool.Use put the item back when its function return an
+// error, for errors which do not break the item. Items are discarded otherwise.
+func WithKeepOnError() Option {
+	return func(o *options) {
+		o.keepOnError = true
+	}
+}
+
+// WithPauseWait makes Get wait for Pool.Resume while the pool is paused, instead
+// of failing with ErrPoolPaused. TryGet still fails right away.
+func WithPauseWait() Option {
+	return func(o *options) {
+		o.pauseWait = true
+	}
+}
+
+// WithCloseTimeout bounds the time the pool waits for an item to close. Close
+// keeps running in the background past the timeout, and the slow close is
+// counted in Stats.SlowCloses. Without it, a hanging Close blocks the pool.
+func WithCloseTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.closeTimeout = d
+	}
+}
+
+// WithAutoClose closes the pool once no item has been checked out for idleFor,
+// so that a forgotten pool does not leak its items. onAutoClose is called after
+// the pool is closed this way, it may be nil.
+func WithAutoClose(idleFor time.Duration, onAutoClose func()) Option {
+	return func(o *options) {
+		o.autoClose = idleFor
+		o.onAutoClose = onAutoClose
+	}
+}
+
+// WithContext ties the pool to ctx: the pool is closed when ctx is done, which
+// unblocks the callers waiting in Get. Closing the pool first is fine, the
+// pool stops watching ctx.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithWeight makes max active a budget shared by items of different cost. An
+// item takes weight(item) active slots while checked out, at least one and at
+// most max active. weight must return the same value for an item every time.
+// Release gives back a single slot, use ReleaseItem instead.
+func WithWeight(weight func(io.Closer) int) Option {
+	return func(o *options) {
+		o.weight = weight
+	}
+}
+
+// WithValidate sets the function to check idle items before they are handed
+// out. See Pool.SetValidate.
+func WithValidate(validate func(io.Closer) bool) Option {
+	return func(o *options) {
+		o.validate = ignoreContext(validate)
+	}
+}
+
+// WithValidateContext is like WithValidate, but validate receives the context
+// of the Get call. If the context is done before validate return, Get does not
+// wait for it, the item is closed as invalid once validate return, and Get
+// return the context error without trying the other idle items.
+func WithValidateContext(validate func(ctx context.Context, item io.Closer) bool) Option {
+	return func(o *options) {
+		o.validate = validate
+	}
+}
+
+// WithOnCreate sets a hook called after the factory creates an item, along with
+// how long the factory took.
+func WithOnCreate(onCreate func(item io.Closer, elapsed time.Duration)) Option {
+	return func(o *options) {
+		o.onCreate = onCreate
+	}
+}
+
+// WithOnClose sets a hook called after the pool closes an item, along with the
+// reason why it was closed. Close and Clear call it without holding the lock.
+func WithOnClose(onClose func(item io.Closer, reason CloseReason)) Option {
+	return func(o *options) {
+		o.onClose = onClose
+	}
+}
+
+// WithOnGet sets a hook called when an item is handed out, along with how long
+// the caller waited for an active slot and whether the item was created for it
+// rather than reused.
+func WithOnGet(onGet func(item io.Closer, waited time.Duration, created bool)) Option {
+	return func(o *options) {
+		o.onGet = onGet
+	}
+}
+
+// WithOnPut sets a hook called when an item is put back, along with whether it
+// went back in the pool or was closed.
+func WithOnPut(onPut func(item io.Closer, pooled bool)) Option {
+	return func(o *options) {
+		o.onPut = onPut
+	}
+}
+
+// WithLeakDetection records the stack trace of every Get, items checked out for
+// longer than threshold are reported by Pool.LeakedItems.
+func WithLeakDetection(threshold time.Duration) Option {
+	return func(o *options) {
+		o.leakThreshold = threshold
+	}
+}
+
+// WithOwnerCheck tracks the checked out items, so that Pool.Owns knows them and
+// items which do not come from the pool are rejected by Put, PutContext,
+// Discard and ReleaseItem without touching the active slots. As with leak
+// detection, use ReleaseItem rather than Release. Items which are not
+// comparable cannot be told apart and are not checked.
+func WithOwnerCheck() Option {
+	return func(o *options) {
+		o.ownerCheck = true
+	}
+}
+
+// WithOnReturn sets a hook called when an item is put back, before it goes into
+// the pool. If the hook return an error, the item is closed instead.
+func WithOnReturn(onReturn func(io.Closer) error) Option {
+	return func(o *options) {
+		o.onReturn = nil
+		if onReturn != nil {
+			o.onReturn = func(_ context.Context, item io.Closer) error {
+				return onReturn(item)
+			}
+		}
+	}
+}
+
+// WithOnReturnContext is like WithOnReturn, but the hook receives the context
+// of PutContext, or context.Background() for Put. If the context is done
+// before the hook return, the caller does not wait for it, so a stuck reset does
+// not hold the caller, and the item is closed instead of pooled once the hook
+// return. A panic in the hook closes the item and is raised again in the caller.
+func WithOnReturnContext(onReturn func(ctx context.Context, item io.Closer) error) Option {
+	return func(o *options) {
+		o.onReturn = onReturn
+	}
+}
+
+// WithOnExhausted sets a hook called when a Get has to wait because all active
+// slots are in use. It is called once until the pool becomes available again.
+func WithOnExhausted(onExhausted func()) Option {
+	return func(o *options) {
+		o.onExhausted = onExhausted
+	}
+}
+
+// WithOnAvailable sets a hook called when an exhausted pool has served all its
+// waiters and has a free active slot again.
+func WithOnAvailable(onAvailable func()) Option {
+	return func(o *options) {
+		o.onAvailable = onAvailable
+	}
+}
+
+// WithHealthCheck sets the function run on idle items by the background health
+// check. See Pool.StartHealthCheck.
+func WithHealthCheck(healthCheck func(io.Closer) bool) Option {
+	return func(o *options) {
+		o.healthCheck = healthCheck
+	}
+}
@@ -0,0 +1,58 @@
+package pool
+
+import "context"
+
+// Pause stops handing out items until Resume is called, for maintenance. Get
+// fails with ErrPoolPaused, or waits for Resume with WithPauseWait, including
+// the callers already waiting for an active slot. Unlike
+// Freeze, Put and Release keep working, so that checked out items can come
+// back, and the idle items are kept. Pausing a paused pool does nothing.
+func (p *Pool) Pause() {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	if p.resumed == nil {
+		p.resumed = make(chan struct{})
+		p.paused.Store(true)
+	}
+}
+
+// Resume hands out items again after Pause, and wakes up the callers waiting
+// for it.
+func (p *Pool) Resume() {
+	p.pauseLock.Lock()
+	defer p.pauseLock.Unlock()
+	if p.resumed != nil {
+		p.paused.Store(false)
+		close(p.resumed)
+		p.resumed = nil
+	}
+}
+
+// IsPaused reports whether the pool is paused.
+func (p *Pool) IsPaused() bool {
+	return p.paused.Load()
+}
+
+// waitResume return ErrPoolPaused if the pool is paused, or waits until it is
+// resumed with WithPauseWait.
+func (p *Pool) waitResume(ctx context.Context) error {
+	for p.paused.Load() {
+		if !p.pauseWait {
+			return ErrPoolPaused
+		}
+		p.pauseLock.Lock()
+		resumed := p.resumed
+		p.pauseLock.Unlock()
+		if resumed == nil {
+			return nil
+		}
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.done:
+			return ErrPoolClosed
+		}
+	}
+	return nil
+}
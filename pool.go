@@ -3,18 +3,120 @@ package pool
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrPoolClosed is returned when using a closed pool.
+	ErrPoolClosed = errors.New("pool is closed")
+	// ErrPoolExhausted is returned by TryGet when all active slots are in use.
+	ErrPoolExhausted = errors.New("pool is exhausted")
+	// ErrOverRelease is returned by Release when no item is checked out.
+	ErrOverRelease = errors.New("release without checked out item")
+	// ErrInvalidMaxActive is returned when max active is not positive.
+	ErrInvalidMaxActive = errors.New("max active must be positive")
+	// ErrInvalidMaxIdle is returned when max idle is negative.
+	ErrInvalidMaxIdle = errors.New("max idle must be non-negative")
+	// ErrBatchTooLarge is returned by GetBatch when the batch needs more active
+	// slots than maxActive.
+	ErrBatchTooLarge = errors.New("batch is larger than max active")
+	// ErrInvalidFillCount is returned by FillTo when the count is negative.
+	ErrInvalidFillCount = errors.New("fill count must be non-negative")
+	// ErrNotOwned is returned when giving back an item which does not come
+	// from the pool, with WithOwnerCheck.
+	ErrNotOwned = errors.New("item does not come from the pool")
+	// ErrTooManyWaiters is returned by Get when the number of callers waiting
+	// for an active slot reached the limit given to WithMaxWaiters.
+	ErrTooManyWaiters = errors.New("too many callers waiting for the pool")
+	// ErrNilItem is returned when the factory creates a nil item, which is
+	// counted as a failed creation, and when putting back a nil item.
+	ErrNilItem = errors.New("nil item")
+	// ErrPoolPaused is returned by Get while the pool is paused.
+	ErrPoolPaused = errors.New("pool is paused")
 )
 
 type Pool struct {
-	lock      sync.RWMutex
-	maxActive int
-	maxIdle   int
-	new       func() (io.Closer, error)
-	active    chan int
-	pool      chan io.Closer
-	closed    bool
+	// lock guards the configuration and the structural operations. Get, Put
+	// and Release do not take it: what they read is atomic, the idle list has
+	// its own mutex and refuses items once Close closed it, and they only wait
+	// for the lock while the pool is frozen.
+	lock            sync.RWMutex
+	maxActive       int
+	maxIdle         int
+	minIdle         int
+	lowWater        int
+	maxIdleTime     atomic.Int64 // time.Duration, read by Get and Put without the lock
+	maxLifetime     atomic.Int64 // time.Duration, likewise
+	maxUsage        int
+	waitTimeout     time.Duration
+	closeTimeout    time.Duration
+	putGrace        time.Duration
+	keepOnError     bool
+	ownerCheck      bool
+	createdOrder    bool // the creation time of items must survive a checkout
+	retries         int
+	retryBackoff    time.Duration
+	weight          func(io.Closer) int
+	new             func(context.Context) (io.Closer, error)
+	validate        atomic.Pointer[func(context.Context, io.Closer) bool]
+	healthCheck     func(io.Closer) bool
+	onCreate        func(io.Closer, time.Duration)
+	onClose         func(io.Closer, CloseReason)
+	onGet           func(io.Closer, time.Duration, bool)
+	onPut           func(io.Closer, bool)
+	onReturn        func(context.Context, io.Closer) error
+	onExhausted     func()
+	onAvailable     func()
+	creating        chan struct{} // factory calls in takeOrCreate, nil if unlimited
+	exhausted       atomic.Bool
+	checkedOut      atomic.Int64 // items handed out and not returned yet
+	lastUsed        atomic.Int64 // unix nano of the last checkout or return, 0 without auto close
+	refilling       atomic.Bool
+	frozen          atomic.Bool
+	active          *semaphore
+	idle            *idleList
+	closed          atomic.Bool // set under lock, read without it on the hot path
+	draining        atomic.Bool
+	done            chan struct{}
+	stopReaper      chan struct{}
+	stopAutoscaler  chan struct{}
+	stopHealthCheck chan struct{}
+
+	breaker  breaker
+	burst    burst
+	failures *failureRate
+
+	counters counters
+
+	pauseLock sync.Mutex
+	paused    atomic.Bool
+	resumed   chan struct{} // closed by Resume, nil if not paused
+	pauseWait bool
+
+	meta *metaStore
+
+	// checked out items, only tracked with maxLifetime or leak detection
+	borrowedLock  sync.Mutex
+	borrowed      map[io.Closer]*borrow
+	leakThreshold time.Duration
+
+	// last items closed when put back, to ignore putting them back twice
+	putClosed closedSet
+}
+
+// idleItem is an item sitting in the pool along with the time it was created,
+// the time it was put back and how many times it was handed out.
+type idleItem struct {
+	item     io.Closer
+	created  time.Time
+	returned time.Time
+	usage    int
+	weight   int    // active slots taken while checked out
+	key      string // set by the idle list with WithKeyFunc
 }
 
 // New create a new pool. Factory function will be called when there is no item
@@ -22,159 +124,1501 @@ type Pool struct {
 // in maxIdle. maxActive can be less than maxIdle, and maxIdle will be set to
 // maxActive in that case.
 //
-// The pool will not be filled when created, use Fill() to fill the pool.
+// A maxIdle of 0 makes the pool a limiter of concurrent items without reuse:
+// Get always creates an item, Put always closes it, Fill does nothing and
+// IdleNum is always 0.
+//
+// The pool will not be filled when created, use Fill() to fill the pool, or
+// WithInitialIdle.
 func New(factory func() (io.Closer, error), maxActive, maxIdle int) (*Pool, error) {
-	if maxActive <= 0 {
-		return nil, errors.New("max active must be positive")
+	return NewWithOptions(factory, WithMaxActive(maxActive), WithMaxIdle(maxIdle))
+}
+
+// NewWithOptions create a new pool configured by opts. WithMaxActive must be
+// given, other options are optional. See New for the pool behaviour.
+func NewWithOptions(factory func() (io.Closer, error), opts ...Option) (*Pool, error) {
+	return NewContext(func(context.Context) (io.Closer, error) {
+		return factory()
+	}, opts...)
+}
+
+// NewContext is like NewWithOptions, but the factory receives the context of the
+// Get call, so that a slow creation can be cancelled by the caller. Fill passes
+// its own context, and background creations use context.Background().
+func NewContext(factory func(ctx context.Context) (io.Closer, error), opts ...Option) (*Pool, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	if maxIdle < 0 {
-		return nil, errors.New("max idle must be non-negative")
+	if o.maxActive <= 0 {
+		return nil, ErrInvalidMaxActive
 	}
-	if maxIdle > maxActive {
-		maxIdle = maxActive
+	if !o.maxIdleSet {
+		o.maxIdle = o.maxActive
+	}
+	if o.maxIdle < 0 {
+		return nil, ErrInvalidMaxIdle
+	}
+	if o.maxIdle > o.maxActive {
+		o.maxIdle = o.maxActive
+	}
+	p := &Pool{
+		maxActive:     o.maxActive,
+		maxIdle:       o.maxIdle,
+		minIdle:       o.minIdle,
+		lowWater:      o.lowWater,
+		maxUsage:      o.maxUsage,
+		waitTimeout:   o.waitTimeout,
+		closeTimeout:  o.closeTimeout,
+		putGrace:      o.putGrace,
+		keepOnError:   o.keepOnError,
+		pauseWait:     o.pauseWait,
+		ownerCheck:    o.ownerCheck,
+		createdOrder:  o.createdOrder && !o.expiryOrder,
+		retries:       o.retries,
+		retryBackoff:  o.retryBackoff,
+		weight:        o.weight,
+		new:           factory,
+		healthCheck:   o.healthCheck,
+		onCreate:      o.onCreate,
+		onClose:       o.onClose,
+		onGet:         o.onGet,
+		onPut:         o.onPut,
+		onReturn:      o.onReturn,
+		onExhausted:   o.onExhausted,
+		onAvailable:   o.onAvailable,
+		active:        newSemaphore(o.maxActive),
+		done:          make(chan struct{}),
+		borrowed:      make(map[io.Closer]*borrow),
+		leakThreshold: o.leakThreshold,
+		putClosed:     closedSet{size: o.maxActive},
+		breaker:       breaker{threshold: o.backoffAfter, cooldown: o.backoffFor},
+		burst:         burst{extra: o.burst, window: o.burstWindow},
+		failures:      newFailureRate(o.failureWindow),
+	}
+	p.active.limit = o.maxWaiters
+	p.maxIdleTime.Store(int64(o.maxIdleTime))
+	p.maxLifetime.Store(int64(o.maxLifetime))
+	p.setValidate(o.validate)
+	p.meta = o.meta
+	if p.meta == nil {
+		p.meta = &metaStore{}
+	}
+	if o.maxCreating > 0 {
+		p.creating = make(chan struct{}, o.maxCreating)
+	}
+	var s idleStore = &sliceStore{lifo: o.lifo, deadline: p.deadline}
+	if o.expiryOrder {
+		s = &heapStore{deadline: p.deadline}
+	} else if o.createdOrder {
+		s = &heapStore{deadline: p.deadline, order: func(it idleItem) time.Time { return it.created }}
 	}
-	return &Pool{
-		maxActive: maxActive,
-		maxIdle:   maxIdle,
-		new:       factory,
-		active:    make(chan int, maxActive),
-		pool:      make(chan io.Closer, maxIdle),
-		closed:    false,
-	}, nil
+	p.idle = newIdleList(o.maxIdle, s)
+	if o.keyOf != nil {
+		p.idle.limitKeys(o.keyOf, o.maxPerKey)
+	}
+	if o.initialIdle > 0 {
+		if err := p.FillTo(o.initialIdle); err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+	}
+	if o.autoClose > 0 {
+		p.touch()
+		go p.autoClose(o.autoClose, o.onAutoClose)
+	}
+	if o.ctx != nil && o.ctx.Done() != nil {
+		go p.closeOnDone(o.ctx)
+	}
+	return p, nil
 }
 
 // Get return an item from the pool. If the active items exceed maxActive, it
-// will block until some item finishes or the context is done. If the pool is
-// empty, a new item will be created and returned.
+// will block until some item finishes or the context is done. Blocked callers
+// are queued and served in the order they arrive, a caller arriving while
+// others wait does not get ahead of them even if a slot is free. If the pool is
+// empty, a new item will be created and returned. Error from the factory is
+// returned to the caller and the active slot is released. A context already done
+// makes Get return its error right away.
 func (p *Pool) Get(ctx context.Context) (io.Closer, error) {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	if p.closed {
-		return nil, errors.New("pool is closed")
+	return p.GetPriority(ctx, 0)
+}
+
+// GetPriority is like Get, but when waiting for an active slot, callers with a
+// higher prio are served first. Callers with the same prio are served in the
+// order they arrive. Get uses prio 0.
+func (p *Pool) GetPriority(ctx context.Context, prio int) (io.Closer, error) {
+	return p.get(ctx, prio, p.takeOrCreate)
+}
+
+// GetFresh is like Get, but always creates a new item instead of taking an
+// idle one, for example to sidestep possibly stale idle items. The item is put
+// back in the pool as usual.
+func (p *Pool) GetFresh(ctx context.Context) (io.Closer, error) {
+	return p.get(ctx, 0, func(ctx context.Context) (idleItem, bool, error) {
+		if p.IsClosed() {
+			return idleItem{}, false, ErrPoolClosed
+		}
+		it, err := p.createItem(ctx, true)
+		return it, true, err
+	})
+}
+
+// GetWith is like GetFresh, but creates the item with factory instead of the
+// pool factory, for example to reach a specific shard. It never reuses idle
+// items, which may not fit, but the item is put back as usual and later Gets
+// may reuse it. Retries and the factory backoff do not apply to factory.
+func (p *Pool) GetWith(ctx context.Context, factory func(context.Context) (io.Closer, error)) (io.Closer, error) {
+	return p.get(ctx, 0, func(ctx context.Context) (idleItem, bool, error) {
+		if p.IsClosed() {
+			return idleItem{}, false, ErrPoolClosed
+		}
+		start := time.Now()
+		item, err := callFactory(ctx, factory)
+		if err != nil {
+			return idleItem{}, false, err
+		}
+		p.counters.created.Add(1)
+		if p.onCreate != nil {
+			p.onCreate(item, time.Since(start))
+		}
+		return idleItem{item: item, created: time.Now(), usage: 1}, true, nil
+	})
+}
+
+// get reserves an active slot and hands out the item given by take.
+func (p *Pool) get(ctx context.Context, prio int, take func(context.Context) (idleItem, bool, error)) (io.Closer, error) {
+	waited, err := p.acquire(ctx, 1, prio)
+	if err != nil {
+		return nil, err
 	}
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case p.active <- 1:
-		return p.takeOrCreate()
+
+	it, created, err := p.takeOrRelease(ctx, take)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.reserveWeight(ctx, prio, &it); err != nil {
+		p.requeue(it)
+		return nil, err
 	}
+	p.handOut(it, waited, created)
+	return it.item, nil
 }
 
-func (p *Pool) takeOrCreate() (item io.Closer, err error) {
-	select {
-	case item = <-p.pool:
-	default:
-		item, err = p.new()
+// takeOrRelease return the item given by take, or gives back the active slot
+// reserved for it if take fails or panics, otherwise failed creations would
+// shrink the pool capacity permanently.
+func (p *Pool) takeOrRelease(ctx context.Context, take func(context.Context) (idleItem, bool, error)) (idleItem, bool, error) {
+	taken := false
+	defer func() {
+		if !taken {
+			p.releaseSlot()
+		}
+	}()
+	it, created, err := take(ctx)
+	taken = err == nil
+	return it, created, err
+}
+
+// handOut records an item given to a caller and reports it to the OnGet hook.
+func (p *Pool) handOut(it idleItem, waited time.Duration, created bool) {
+	if created {
+		// the factory may give back an item it created before
+		p.putClosed.remove(it.item)
+	}
+	p.track(it)
+	p.checkOut(1)
+	p.counters.gets.Add(1)
+	if p.onGet != nil {
+		p.onGet(it.item, waited, created)
+	}
+}
+
+// acquire reserves n active slots, waiting until they are free or the context
+// is done. It return the time spent waiting, which is also counted in the
+// stats.
+func (p *Pool) acquire(ctx context.Context, n, prio int) (time.Duration, error) {
+	// without the lock: a Close racing with it closes the semaphore, and
+	// takeValid checks again
+	p.waitThawed()
+	if p.closed.Load() || p.draining.Load() {
+		return 0, ErrPoolClosed
+	}
+	// a done context never takes a slot, even if one is free
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var waited time.Duration
+	for {
+		if err := p.waitResume(ctx); err != nil {
+			return waited, err
+		}
+		d, err := p.acquireSlots(ctx, n, prio)
+		waited += d
+		if err != nil || !p.paused.Load() {
+			return waited, err
+		}
+		// paused while waiting: the slots are given back, so that the
+		// pool drains instead of serving the queued callers
+		p.releaseSlots(n)
 	}
-	return
 }
 
-// Put add back item in the pool. If the pool is full, the item will be closed.
+// acquireSlots is acquire, once the pool is not paused.
+func (p *Pool) acquireSlots(ctx context.Context, n, prio int) (time.Duration, error) {
+	if p.active.tryAcquire(n) || p.burst.take(n) {
+		return 0, nil
+	}
+	if p.exhausted.CompareAndSwap(false, true) && p.onExhausted != nil {
+		p.onExhausted()
+	}
+	if p.waitTimeout > 0 {
+		// keeps the caller deadline if it is earlier
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.waitTimeout)
+		defer cancel()
+	}
+	// the lock must not be held while waiting for a slot, otherwise a
+	// pending Close blocks every Put that could free one
+	start := time.Now()
+	err := p.active.acquire(ctx, n, prio)
+	if err == ErrTooManyWaiters {
+		return 0, err
+	}
+	d := time.Since(start)
+	p.counters.waitCount.Add(1)
+	p.counters.waitDuration.Add(int64(d))
+	return d, err
+}
+
+// WaitForIdle blocks until an active slot is free, the context is done or the
+// pool is closed. It does not reserve the slot, so a Get afterwards may still
+// wait.
+func (p *Pool) WaitForIdle(ctx context.Context) error {
+	for {
+		free, freed := p.active.free()
+		if free {
+			return nil
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-p.done:
+			return ErrPoolClosed
+		}
+	}
+}
+
+// GetWithTimeout is like Get but waits at most d for an active slot, in which
+// case context.DeadlineExceeded is returned.
+func (p *Pool) GetWithTimeout(d time.Duration) (io.Closer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return p.Get(ctx)
+}
+
+// TryGet return an item from the pool without blocking. If the active items
+// reach maxActive, or WithMaxCreating is reached while the pool is empty,
+// ErrPoolExhausted is returned and no item is created. Failed creations are not
+// retried.
+func (p *Pool) TryGet() (io.Closer, error) {
+	p.waitThawed()
+	if p.closed.Load() || p.draining.Load() {
+		return nil, ErrPoolClosed
+	}
+	if p.paused.Load() {
+		return nil, ErrPoolPaused
+	}
+	if !p.active.tryAcquire(1) && !p.burst.take(1) {
+		return nil, ErrPoolExhausted
+	}
+	it, created, err := p.takeOrRelease(context.Background(), func(ctx context.Context) (idleItem, bool, error) {
+		return p.takeOrCreateWait(ctx, false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !p.tryReserveWeight(&it) {
+		p.requeue(it)
+		p.releaseSlot()
+		return nil, ErrPoolExhausted
+	}
+	p.handOut(it, 0, created)
+	return it.item, nil
+}
+
+// takeOrCreate return an idle item or a new one, and whether it was created.
+// The caller must track it once it is handed out. Neither the factory nor Close
+// of the items are called with the lock held, so that they can call back into
+// the pool. With WithMaxCreating, a caller waiting to create an item takes an
+// item returned in the meantime instead.
+func (p *Pool) takeOrCreate(ctx context.Context) (idleItem, bool, error) {
+	return p.takeOrCreateWait(ctx, true)
+}
+
+// takeOrCreateWait is takeOrCreate. Without wait, as for TryGet, it return
+// ErrPoolExhausted rather than wait for another creation, and failed creations
+// are not retried.
+func (p *Pool) takeOrCreateWait(ctx context.Context, wait bool) (idleItem, bool, error) {
+	for {
+		it, ok, err := p.takeValid(ctx)
+		if err != nil {
+			return idleItem{}, false, err
+		}
+		p.startRefill()
+		if ok {
+			it.usage++
+			return it, false, nil
+		}
+		if p.creating == nil {
+			it, err := p.createItem(ctx, wait)
+			return it, true, err
+		}
+		if !wait {
+			select {
+			case p.creating <- struct{}{}:
+				defer func() { <-p.creating }()
+				it, err := p.createItem(ctx, false)
+				return it, true, err
+			default:
+				return idleItem{}, false, ErrPoolExhausted
+			}
+		}
+		select {
+		case p.creating <- struct{}{}:
+			defer func() { <-p.creating }()
+			it, err := p.createItem(ctx, true)
+			return it, true, err
+		case <-p.idle.arrival():
+			// an item was returned, try to take it
+		case <-ctx.Done():
+			return idleItem{}, false, ctx.Err()
+		case <-p.done:
+			return idleItem{}, false, ErrPoolClosed
+		}
+	}
+}
+
+// takeValid takes the next idle item, closing the expired or invalid ones. It
+// return false only if the pool is empty: the idle list is guarded by a mutex,
+// so concurrent callers never miss an idle item and create one instead.
+func (p *Pool) takeValid(ctx context.Context) (idleItem, bool, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			// only the item abandoned to validate is dropped, not every
+			// idle item left
+			return idleItem{}, false, err
+		}
+		if p.closed.Load() || p.draining.Load() {
+			return idleItem{}, false, ErrPoolClosed
+		}
+		it, ok := p.idle.pop()
+		if !ok {
+			return idleItem{}, false, nil
+		}
+		if p.expired(it) {
+			p.closeItem(it.item, ReasonExpired)
+			continue
+		}
+		if validate := p.validate.Load(); validate != nil && !untilDone(ctx, func() bool { return (*validate)(ctx, it.item) }, func() {
+			p.closeItem(it.item, ReasonInvalid)
+		}) {
+			continue
+		}
+		return it, true, nil
+	}
+}
+
+// createItem creates an item to hand out, retrying failed creations as set by
+// WithGetRetries if retry is set.
+func (p *Pool) createItem(ctx context.Context, retry bool) (idleItem, error) {
+	retries := 0
+	if retry {
+		retries = p.retries
+	}
+	item, err := p.create(ctx)
+	for i := 0; err != nil && i < retries; i++ {
+		if errors.Is(err, ErrFactoryBackoff) {
+			break
+		}
+		timer := time.NewTimer(p.retryBackoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return idleItem{}, ctx.Err()
+		case <-p.done:
+			timer.Stop()
+			return idleItem{}, ErrPoolClosed
+		}
+		item, err = p.create(ctx)
+	}
+	if err != nil {
+		return idleItem{}, err
+	}
+	return idleItem{item: item, created: time.Now(), usage: 1}, nil
+}
+
+// expired reports whether an idle item exceeds maxIdleTime, maxLifetime or
+// maxUsage.
+func (p *Pool) expired(it idleItem) bool {
+	if idleTime := time.Duration(p.maxIdleTime.Load()); idleTime > 0 && time.Since(it.returned) > idleTime {
+		return true
+	}
+	if p.maxUsage > 0 && it.usage >= p.maxUsage {
+		return true
+	}
+	lifetime := time.Duration(p.maxLifetime.Load())
+	return lifetime > 0 && time.Since(it.created) > lifetime
+}
+
+// deadline return when an idle item expires due to maxIdleTime or maxLifetime,
+// or the zero time if it does not.
+func (p *Pool) deadline(it idleItem) time.Time {
+	var d time.Time
+	if idleTime := time.Duration(p.maxIdleTime.Load()); idleTime > 0 {
+		d = it.returned.Add(idleTime)
+	}
+	if lifetime := time.Duration(p.maxLifetime.Load()); lifetime > 0 {
+		if l := it.created.Add(lifetime); d.IsZero() || l.Before(d) {
+			d = l
+		}
+	}
+	return d
+}
+
+// releaseSlot gives back an active slot.
+func (p *Pool) releaseSlot() {
+	p.releaseSlots(1)
+}
+
+// releaseSlots gives back n active slots.
+func (p *Pool) releaseSlots(n int) {
+	// the burst drains first, so that the pool gets back under maxActive
+	p.active.release(p.burst.give(n))
+	// the pool is available again once all waiters are served, so that
+	// hovering at the limit does not flip the state on every release
+	if p.exhausted.Load() && p.active.hasRoom() && p.exhausted.CompareAndSwap(true, false) && p.onAvailable != nil {
+		p.onAvailable()
+	}
+}
+
+// Put add back item in the pool. If the pool is full, the item will be closed,
+// after waiting for room up to the grace given by WithPutGrace if any. If the
+// pool is closed, the item is closed and reported to OnClose with
+// ReasonShutdown, use PutContext to get the error from its Close.
+// Putting an item which is already idle in the pool, or which was just closed
+// when put back, does nothing, as does putting back more items than checked
+// out, in which case the item is left to the caller.
 func (p *Pool) Put(item io.Closer) {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	if p.closed {
-		_ = item.Close()
-		return
+	p.TryPut(item)
+}
+
+// TryPut is like Put, and reports whether the item went back in the pool. It
+// return false if the item was closed instead, because the pool is full or
+// closed, or the item expired or failed OnReturn. With WithOwnerCheck, it also
+// return false if the item does not come from the pool, which is left as is. A
+// nil item is ignored.
+func (p *Pool) TryPut(item io.Closer) bool {
+	pooled := p.put(item)
+	if p.onPut != nil {
+		p.onPut(item, pooled)
 	}
-	select {
-	case p.pool <- item:
+	return pooled
+}
+
+func (p *Pool) put(item io.Closer) bool {
+	if item == nil || p.foreign(item) {
+		return false
+	}
+	p.waitThawed()
+	if p.idle.has(item) {
+		// put twice, the slot was already released by the first one
+		return true
+	}
+	if p.putClosed.has(item) {
+		return false
+	}
+	if p.closed.Load() {
+		p.counters.puts.Add(1)
+		_ = p.dropPut(item, ReasonShutdown)
+		return false
+	}
+	if !p.checkIn() {
+		// more puts than items checked out, the slot is not ours to free
+		return false
+	}
+	p.counters.puts.Add(1)
+	it := p.untrack(item)
+	expired := p.expired(it)
+	// release the slot even if OnReturn panics, and only once the item is
+	// stored, so that the Get woken up by the slot finds it
+	defer p.releaseSlots(it.weight)
+
+	var reason CloseReason
+	switch {
+	case expired:
+		reason = ReasonExpired
+	case p.burst.len() > 0:
+		// over maxActive, the burst items are not kept
+		reason = ReasonPoolFull
+	case !p.reset(context.Background(), item):
+		return false
 	default:
-		item.Close()
+		reason = p.storeWithGrace(it)
 	}
+	if reason == reasonNone {
+		return true
+	}
+	_ = p.dropPut(item, reason)
+	return false
+}
+
+// dropPut closes an item put back which is not kept, and remembers it so that
+// putting it back again does nothing. It return the error from the item Close,
+// which is ignored for a full pool.
+func (p *Pool) dropPut(item io.Closer, reason CloseReason) error {
+	p.putClosed.add(item)
+	if reason == ReasonPoolFull {
+		p.overflow(item)
+		return nil
+	}
+	return p.closeItem(item, reason)
+}
 
+// store puts an item in the pool, or return why it should be closed instead,
+// reasonNone if it was stored.
+func (p *Pool) store(it idleItem) CloseReason {
+	if p.closed.Load() {
+		return ReasonShutdown
+	}
+	if !p.idle.push(it) {
+		return p.refused()
+	}
+	return reasonNone
+}
+
+// refused return why the idle list refused an item: the pool is full, or it
+// is closed and the idle list with it, in which case the closed flag is set
+// already.
+func (p *Pool) refused() CloseReason {
+	if p.closed.Load() {
+		return ReasonShutdown
+	}
+	return ReasonPoolFull
+}
+
+// storeWithGrace is storeReset, waiting up to putGrace for room if the pool is
+// full.
+func (p *Pool) storeWithGrace(it idleItem) CloseReason {
+	if p.putGrace <= 0 {
+		reason, _ := p.storeReset(context.Background(), it, false)
+		return reason
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.putGrace)
+	defer cancel()
+	reason, _ := p.storeReset(ctx, it, true)
+	return reason
+}
+
+// storeReset resets an item and puts it in the pool, or return why it should be
+// closed instead. Room is reserved before the item is reset, so that an item
+// which is closed anyway is not reset. With wait, it waits for room until the
+// context is done, in which case it return ReasonPoolFull and the context
+// error. It does not take the lock: the idle list refuses items once Close
+// closed it, so an item is never left in a closed pool.
+func (p *Pool) storeReset(ctx context.Context, it idleItem, wait bool) (CloseReason, error) {
+	for {
+		if p.closed.Load() {
+			return ReasonShutdown, nil
+		}
+		ok, retry := p.idle.reserve(&it)
+		if ok {
+			break
+		}
+		if !wait || !retry {
+			// full, resized to maxIdle 0 or over the limit of its key
+			return p.refused(), nil
+		}
+		select {
+		case <-p.idle.wait():
+		case <-ctx.Done():
+			return ReasonPoolFull, ctx.Err()
+		case <-p.done:
+			return ReasonShutdown, nil
+		}
+	}
+
+	resetItem(it.item)
+	if !p.idle.commit(it) {
+		return p.refused(), nil
+	}
+	return reasonNone, nil
+}
+
+// Resetter is implemented by items which can be reset to a clean state, like
+// buffers or encoders. Reset is called when the item is put back, after
+// OnReturn, once there is room for it in the pool. Items which are closed
+// instead, for example because the pool stays full until the end of the grace
+// given by WithPutGrace or the context of PutContext, are not reset.
+type Resetter interface {
+	Reset()
+}
+
+// resetItem calls Reset if item is a Resetter.
+func resetItem(item io.Closer) {
+	if r, ok := item.(Resetter); ok {
+		r.Reset()
+	}
+}
+
+// reset runs the OnReturn hook and reports whether the item can be pooled.
+// Otherwise the item is closed as invalid, once the hook is done with it.
+func (p *Pool) reset(ctx context.Context, item io.Closer) bool {
+	return p.onReturn == nil || untilDone(ctx, func() bool {
+		return p.onReturn(ctx, item) == nil
+	}, func() {
+		_ = p.dropPut(item, ReasonInvalid)
+	})
+}
+
+// hookResult is the outcome of a hook run by untilDone.
+type hookResult struct {
+	ok       bool
+	panicked bool
+	value    any // recovered from the panic
+}
+
+// untilDone return the result of fn, or false if the context is done first.
+// When it return false, drop is called to get rid of the item used by fn:
+// right away if fn returned false, or in the background once fn returns if the
+// context was done first, so that the item is not closed under fn. A panic in
+// fn drops the item too, and is raised again in the caller if it still waits.
+func untilDone(ctx context.Context, fn func() bool, drop func()) (ok bool) {
+	if ctx.Done() == nil {
+		defer func() {
+			if !ok {
+				drop()
+			}
+		}()
+		return fn()
+	}
+	if ctx.Err() != nil {
+		drop()
+		return false
+	}
+	// running until fn returns, then either returned or abandoned by the
+	// caller, whoever is first
+	const (
+		running = iota
+		returned
+		abandoned
+	)
+	var state atomic.Int32
+	result := make(chan hookResult, 1)
+	go func() {
+		r := hookResult{panicked: true}
+		defer func() {
+			if r.panicked {
+				r.value = recover()
+			}
+			if !state.CompareAndSwap(running, returned) {
+				drop()
+				return
+			}
+			result <- r
+		}()
+		r.ok = fn()
+		r.panicked = false
+	}()
+	var r hookResult
 	select {
-	case <-p.active:
+	case r = <-result:
+	case <-ctx.Done():
+		if state.CompareAndSwap(running, abandoned) {
+			return false
+		}
+		r = <-result
+	}
+	if r.panicked {
+		drop()
+		panic(r.value)
+	}
+	if !r.ok {
+		drop()
+	}
+	return r.ok
+}
+
+// PutContext add back item in the pool. If the pool is full, it blocks until
+// there is room in the pool or the context is done, in which case the item is
+// closed and the context error is returned, as when the context is done before
+// OnReturn return. Putting an item which is already idle in the pool, or which
+// was just closed when put back, does nothing. With maxIdle 0, the item is
+// closed right away. Putting back more items than checked out return
+// ErrOverRelease and leaves the item to the caller. If the pool is closed, the
+// item is closed with ReasonShutdown and ErrPoolClosed is returned, joined with
+// the error from the item Close if any.
+func (p *Pool) PutContext(ctx context.Context, item io.Closer) error {
+	pooled, err := p.putContext(ctx, item)
+	if p.onPut != nil {
+		p.onPut(item, pooled)
+	}
+	return err
+}
+
+// putContext is PutContext, and reports whether the item went back in the pool.
+func (p *Pool) putContext(ctx context.Context, item io.Closer) (bool, error) {
+	if item == nil {
+		return false, ErrNilItem
+	}
+	if p.foreign(item) {
+		return false, ErrNotOwned
+	}
+	p.waitThawed()
+	if p.idle.has(item) {
+		return true, nil
+	}
+	if p.putClosed.has(item) {
+		return false, nil
+	}
+	if p.closed.Load() {
+		p.counters.puts.Add(1)
+		return false, p.closeOnShutdown(item)
+	}
+	if !p.checkIn() {
+		return false, ErrOverRelease
+	}
+	p.counters.puts.Add(1)
+	it := p.untrack(item)
+	expired := p.expired(it)
+	// like put, once the item is stored
+	defer p.releaseSlots(it.weight)
+
+	var reason CloseReason
+	var err error
+	switch {
+	case expired:
+		reason = ReasonExpired
+	case p.burst.len() > 0:
+		reason = ReasonPoolFull
+	case !p.reset(ctx, item):
+		// nil unless the hook was still running at the deadline
+		return false, ctx.Err()
 	default:
+		reason, err = p.storeReset(ctx, it, true)
 	}
+	switch reason {
+	case reasonNone:
+		return true, nil
+	case ReasonShutdown:
+		return false, p.closeOnShutdown(item)
+	}
+	_ = p.dropPut(item, reason)
+	return false, err
+}
+
+// closeOnShutdown closes an item put back in a closed pool, and return
+// ErrPoolClosed joined with the error from the item Close.
+func (p *Pool) closeOnShutdown(item io.Closer) error {
+	if err := p.dropPut(item, ReasonShutdown); err != nil {
+		return errors.Join(ErrPoolClosed, err)
+	}
+	return ErrPoolClosed
 }
 
 // Release the item without put it back in the pool. The function does not
-// close the item.
-func (p *Pool) Release() {
-	p.lock.RLock()
-	defer p.lock.RUnlock()
-	if p.closed {
-		return
+// close the item. Use ReleaseItem with leak detection enabled or metadata,
+// otherwise the released item is reported as leaked and its metadata is kept.
+//
+// It return ErrPoolClosed if the pool is closed, and ErrOverRelease if there
+// are more releases than items checked out, in which case no slot is freed so
+// that the ones of other callers are kept.
+func (p *Pool) Release() error {
+	return p.release(1)
+}
+
+// Discard closes a checked out item instead of putting it back, for example
+// when it is known to be broken, and frees its active slot. It return the error
+// from the item Close, or the errors of ReleaseItem.
+func (p *Pool) Discard(item io.Closer) error {
+	if item == nil {
+		return ErrNilItem
+	}
+	if p.foreign(item) {
+		return ErrNotOwned
+	}
+	err := p.closeItem(item, ReasonInvalid)
+	if rerr := p.ReleaseItem(item); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+func (p *Pool) release(n int) error {
+	p.waitThawed()
+	if p.closed.Load() {
+		return ErrPoolClosed
+	}
+	if !p.checkIn() {
+		return ErrOverRelease
+	}
+	p.counters.releases.Add(1)
+	p.releaseSlots(n)
+	return nil
+}
+
+// checkOut counts n items handed out.
+func (p *Pool) checkOut(n int) {
+	p.checkedOut.Add(int64(n))
+	if p.lastUsed.Load() != 0 {
+		p.touch()
+	}
+}
+
+// checkIn counts an item given back, it return false if none is checked out.
+func (p *Pool) checkIn() bool {
+	for {
+		n := p.checkedOut.Load()
+		if n <= 0 {
+			return false
+		}
+		if p.checkedOut.CompareAndSwap(n, n-1) {
+			if p.lastUsed.Load() != 0 {
+				p.touch()
+			}
+			return true
+		}
 	}
+}
+
+// Close the pool and all the items in it. The items are closed after the lock
+// is released, so their Close may call back into the pool. The errors from the
+// items are joined with errors.Join. Closing a closed pool does nothing.
+//
+// Callers blocked in Get, PutContext or WaitForIdle return ErrPoolClosed right
+// away, whatever their context.
+func (p *Pool) Close() error {
+	return p.CloseContext(context.Background())
+}
+
+// CloseContext is like Close, but the deadline of the context bounds the total
+// time spent closing the items. When the context is done, the items left are
+// closed in the background and counted in Stats.SlowCloses, and an error
+// wrapping the context error is returned.
+func (p *Pool) CloseContext(ctx context.Context) error {
+	p.lock.Lock()
+	if p.closed.Load() {
+		p.lock.Unlock()
+		return nil
+	}
+	p.closed.Store(true)
+	// Put does not take the lock, the idle list refuses its items instead
+	p.idle.close()
+	items := p.takeIdle()
+
+	// wake up the waiters in Get and PutContext
+	p.active.close()
+	close(p.done)
+	p.lock.Unlock()
+
+	return p.closeAllContext(ctx, items, ReasonShutdown)
+}
+
+// closeAllContext is closeAll, giving up waiting for the items when the
+// context is done. The goroutine closing them exits once they are all closed.
+func (p *Pool) closeAllContext(ctx context.Context, items []io.Closer, reason CloseReason) error {
+	if ctx.Done() == nil || len(items) == 0 {
+		return p.closeAll(items, reason)
+	}
+	var left atomic.Int64
+	left.Store(int64(len(items)))
+	result := make(chan error, 1)
+	go func() {
+		var errs []error
+		for _, item := range items {
+			if err := p.closeItem(item, reason); err != nil {
+				errs = append(errs, err)
+			}
+			left.Add(-1)
+		}
+		result <- errors.Join(errs...)
+	}()
 	select {
-	case <-p.active:
-	default:
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		n := left.Load()
+		p.counters.slowCloses.Add(n)
+		return fmt.Errorf("pool closed with %d items still closing: %w", n, ctx.Err())
+	}
+}
+
+// closeAll closes items and return their errors joined.
+func (p *Pool) closeAll(items []io.Closer, reason CloseReason) error {
+	var errs []error
+	for _, item := range items {
+		if err := p.closeItem(item, reason); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
 }
 
-// Close the pool and all the items in it.
-func (p *Pool) Close() {
+// CloseGracefully stops handing out items and waits until all checked out items
+// are returned before closing the pool. If the context is done first, the pool
+// is closed anyway and an error wrapping the context error is returned. The
+// context also bounds the time spent closing the items, like CloseContext, and
+// the errors from closing them are joined to the returned error. The deadline
+// is the budget of the whole shutdown: once it is spent waiting for the items,
+// the idle ones are closed in the background and counted in Stats.SlowCloses.
+func (p *Pool) CloseGracefully(ctx context.Context) error {
 	p.lock.Lock()
-	defer p.lock.Unlock()
-	if p.closed {
-		return
+	if p.closed.Load() {
+		p.lock.Unlock()
+		return nil
 	}
-	p.closed = true
+	p.draining.Store(true)
+	p.lock.Unlock()
 
-outer:
+	err := p.waitReturned(ctx)
+	return errors.Join(err, p.CloseContext(ctx))
+}
+
+// waitReturned blocks until all checked out items are returned, the context is
+// done or the pool is closed.
+func (p *Pool) waitReturned(ctx context.Context) error {
 	for {
+		// the burst is given back before the slots, and every release
+		// notifies, so the last one closes emptied
+		emptied := p.active.emptied()
+		if p.ActiveNum() == 0 {
+			return nil
+		}
 		select {
-		case item := <-p.pool:
-			_ = item.Close()
-		default:
-			break outer
+		case <-ctx.Done():
+			return fmt.Errorf("pool closed with %d active items: %w", p.ActiveNum(), ctx.Err())
+		case <-p.done:
+			return nil
+		case <-emptied:
 		}
 	}
+}
+
+// Resize changes maxActive and maxIdle of the pool. Idle items over the new
+// maxIdle are closed. If more items than the new maxActive are checked out, Get
+// blocks until enough of them are returned.
+func (p *Pool) Resize(maxActive, maxIdle int) error {
+	if maxActive <= 0 {
+		return ErrInvalidMaxActive
+	}
+	if maxIdle < 0 {
+		return ErrInvalidMaxIdle
+	}
+	if maxIdle > maxActive {
+		maxIdle = maxActive
+	}
+
+	p.lock.Lock()
+	if p.closed.Load() {
+		p.lock.Unlock()
+		return ErrPoolClosed
+	}
+	p.active.resize(maxActive)
+	evicted := p.idle.resize(maxIdle)
+	p.maxActive = maxActive
+	p.maxIdle = maxIdle
+	p.lock.Unlock()
+
+	for _, it := range evicted {
+		p.closeItem(it.item, ReasonPoolFull)
+	}
+	return nil
+}
 
-	close(p.pool)
-	close(p.active)
+// Done return a channel which is closed when the pool is closed, so that
+// goroutines using the pool can stop with it.
+func (p *Pool) Done() <-chan struct{} {
+	return p.done
 }
 
-// IsClosed return true if the pool is closed and false otherwise.
+// IsClosed return true if the pool is closed and false otherwise. It does not
+// take the pool lock.
 func (p *Pool) IsClosed() bool {
-	return p.closed
+	return p.closed.Load()
+}
+
+// FillRamp is like FillTo, but creates the items one at a time, at most
+// perSecond items per second, so that warming up a large pool does not hit the
+// backend all at once. It stops when the context is done or the pool is closed,
+// and the items created before are kept. Errors are returned as a *FillError. A
+// perSecond <= 0 does not limit the rate.
+func (p *Pool) FillRamp(ctx context.Context, n, perSecond int) error {
+	if n < 0 {
+		return ErrInvalidFillCount
+	}
+	if perSecond <= 0 {
+		return p.fillTo(ctx, n)
+	}
+	p.lock.RLock()
+	if p.closed.Load() {
+		p.lock.RUnlock()
+		return ErrPoolClosed
+	}
+	need := min(n, p.maxIdle) - p.idle.len()
+	p.lock.RUnlock()
+
+	ticker := time.NewTicker(max(time.Second/time.Duration(perSecond), 1))
+	defer ticker.Stop()
+	placed := 0
+	for i := 0; i < need; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return &FillError{Placed: placed, Requested: need, Err: ctx.Err()}
+			case <-p.done:
+				return &FillError{Placed: placed, Requested: need, Err: ErrPoolClosed}
+			case <-ticker.C:
+			}
+		}
+		item, err := p.create(ctx)
+		if err != nil {
+			return &FillError{Placed: placed, Requested: need, Err: err}
+		}
+		if p.install(item) {
+			placed++
+		}
+	}
+	return nil
 }
 
-// Fill the pool [maxIdle - len(pool)] times.
+// fillWorkers is the number of factory calls running at the same time in Fill.
+const fillWorkers = 8
+
+// Fill the pool [maxIdle - len(pool)] times. Items are created concurrently
+// without holding the lock.
 func (p *Pool) Fill() {
+	_ = p.FillContext(context.Background())
+}
+
+// FillContext fills the pool like Fill, but stops creating items when the
+// context is done or the pool is closed. Items created before that are kept in
+// the pool. The first error from the context or the factory is returned as a
+// *FillError telling how many items were placed.
+func (p *Pool) FillContext(ctx context.Context) error {
+	return p.fillTo(ctx, -1)
+}
+
+// FillTo creates items until the pool holds n idle items, capped at maxIdle.
+// It does nothing if the pool already holds n items or more.
+func (p *Pool) FillTo(n int) error {
+	if n < 0 {
+		return ErrInvalidFillCount
+	}
+	return p.fillTo(context.Background(), n)
+}
+
+// fillTo fills the pool to n idle items, or to maxIdle if n is negative.
+func (p *Pool) fillTo(ctx context.Context, n int) error {
+	p.lock.RLock()
+	if p.closed.Load() {
+		p.lock.RUnlock()
+		return ErrPoolClosed
+	}
+	if n < 0 || n > p.maxIdle {
+		n = p.maxIdle
+	}
+	need := n - p.idle.len()
+	p.lock.RUnlock()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		placed   atomic.Int64
+	)
+	setErr := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+	}
+	sem := make(chan struct{}, fillWorkers)
+loop:
+	for i := 0; i < need; i++ {
+		select {
+		case <-ctx.Done():
+			setErr(ctx.Err())
+			break loop
+		case <-p.done:
+			// closed while filling
+			setErr(ErrPoolClosed)
+			break loop
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			item, err := p.create(ctx)
+			if err != nil {
+				setErr(err)
+				return
+			}
+			if p.install(item) {
+				placed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return &FillError{Placed: int(placed.Load()), Requested: need, Err: firstErr}
+	}
+	return nil
+}
+
+// FillError is returned when filling the pool stops early. The items placed
+// before the error are kept in the pool.
+type FillError struct {
+	Placed    int // items put in the pool
+	Requested int // items the pool was missing
+	Err       error
+}
+
+func (e *FillError) Error() string {
+	return fmt.Sprintf("pool filled with %d of %d items: %v", e.Placed, e.Requested, e.Err)
+}
+
+func (e *FillError) Unwrap() error {
+	return e.Err
+}
+
+// install puts a newly created item in the pool. The item is closed if the
+// pool is full or closed.
+func (p *Pool) install(item io.Closer) bool {
+	now := time.Now()
+	reason := p.store(idleItem{item: item, created: now, returned: now})
+	if reason != reasonNone {
+		p.closeItem(item, reason)
+	}
+	return reason == reasonNone
+}
+
+// Adopt puts an item which was not checked out from this pool, for example one
+// taken from another pool, in the pool. It return false if the pool is closed
+// or full, in which case the item is left to the caller. The active items are
+// not changed.
+func (p *Pool) Adopt(item io.Closer) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	if item == nil || p.closed.Load() || p.idle.has(item) {
+		return false
+	}
+	now := time.Now()
+	return p.idle.push(idleItem{item: item, created: now, returned: now})
+}
+
+// Clear all items in the pool. The errors from the items are joined with
+// errors.Join. The items are taken out of the pool at once and closed after
+// the lock is released, so a Close running meanwhile does not wait for them
+// and each item is closed once, by either Clear or Close.
+func (p *Pool) Clear() error {
+	p.lock.Lock()
+	if p.closed.Load() {
+		p.lock.Unlock()
+		return nil
+	}
+	items := p.takeIdle()
+	p.lock.Unlock()
+
+	return p.closeAll(items, ReasonCleared)
+}
+
+// Refresh replaces the idle items by n new ones, capped at maxIdle, for example
+// after rotating credentials. If n is 0 or less, there are as many new items as
+// idle ones, and at least MinIdle. The new items are created first without
+// holding the lock, then swapped in at once, so that Get never finds the pool
+// cold meanwhile. If the factory fails, the new items are closed, the idle
+// items are kept and the error is returned.
+func (p *Pool) Refresh(n int) error {
+	p.lock.RLock()
+	if p.closed.Load() {
+		p.lock.RUnlock()
+		return ErrPoolClosed
+	}
+	if n <= 0 {
+		n = max(p.idle.len(), p.minIdle)
+	}
+	n = min(n, p.maxIdle)
+	p.lock.RUnlock()
+
+	fresh := make([]io.Closer, 0, n)
+	for len(fresh) < n {
+		item, err := p.create(context.Background())
+		if err != nil {
+			_ = p.closeAll(fresh, ReasonCleared)
+			return err
+		}
+		fresh = append(fresh, item)
+	}
+
+	p.lock.Lock()
+	if p.closed.Load() {
+		p.lock.Unlock()
+		return errors.Join(ErrPoolClosed, p.closeAll(fresh, ReasonShutdown))
+	}
+	stale := p.takeIdle()
+	now := time.Now()
+	var rejected []io.Closer
+	for _, item := range fresh {
+		if !p.idle.push(idleItem{item: item, created: now, returned: now}) {
+			// over the limit of its key
+			rejected = append(rejected, item)
+		}
+	}
+	p.lock.Unlock()
+
+	_ = p.closeAll(rejected, ReasonPoolFull)
+	return p.closeAll(stale, ReasonCleared)
+}
+
+// Detach removes all idle items from the pool without closing them and return
+// them, for example to hand them over to a new pool with Adopt. The pool stays
+// usable and creates new items on demand.
+func (p *Pool) Detach() []io.Closer {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	if p.closed {
+	if p.closed.Load() {
+		return nil
+	}
+	items := p.takeIdle()
+	for _, item := range items {
+		p.meta.set(item, nil)
+	}
+	return items
+}
+
+// TrimIdle closes up to n idle items, starting from the ones idle for the
+// longest time, and return how many were closed.
+func (p *Pool) TrimIdle(n int) int {
+	p.lock.RLock()
+	if p.closed.Load() {
+		p.lock.RUnlock()
+		return 0
+	}
+	trimmed := p.idle.trim(n)
+	p.lock.RUnlock()
+
+	for _, it := range trimmed {
+		p.closeItem(it.item, ReasonCleared)
+	}
+	return len(trimmed)
+}
+
+// Drain closes all items in the pool like Clear, and also resets the active
+// items to zero as if every checked out item had been released.
+//
+// WARNING: this invalidates the accounting of items still held by callers.
+// Only use it when all checked out items are known to be abandoned, e.g. after
+// a backend failover. Putting or releasing such items afterwards frees slots
+// taken by other callers.
+func (p *Pool) Drain() {
+	p.lock.Lock()
+	if p.closed.Load() {
+		p.lock.Unlock()
 		return
 	}
-	for i := len(p.pool); i != p.maxIdle; i++ {
-		if item, err := p.new(); err == nil {
-			p.pool <- item
-		}
+	items := p.takeIdle()
+	p.active.reset()
+	p.checkedOut.Store(0)
+	p.borrowedLock.Lock()
+	clear(p.borrowed)
+	p.borrowedLock.Unlock()
+	p.lock.Unlock()
+
+	for _, item := range items {
+		p.closeItem(item, ReasonCleared)
+	}
+}
+
+// takeIdle removes all items from the pool and return them.
+func (p *Pool) takeIdle() []io.Closer {
+	var items []io.Closer
+	for _, it := range p.idle.drain() {
+		items = append(items, it.item)
 	}
+	return items
+}
+
+// SetMaxIdleTime sets the maximum amount of time an item may stay idle in the
+// pool. Expired items are closed when they are taken out of the pool. If d <= 0,
+// items are not closed due to idle time.
+func (p *Pool) SetMaxIdleTime(d time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.maxIdleTime.Store(int64(d))
+}
+
+// SetMaxLifetime sets the maximum amount of time an item may be reused since it
+// was created. Expired items are closed instead of being handed out or put back.
+// If d <= 0, items are not closed due to their age.
+func (p *Pool) SetMaxLifetime(d time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.maxLifetime.Store(int64(d))
 }
 
-// Clear all items in the pool.
-func (p *Pool) Clear() {
+// SetValidate sets a function to check idle items before they are handed out.
+// Items failing the check are closed and the next idle item is tried. A new
+// item is created if none of the idle items is valid. A panic in validate closes
+// the item and is raised again in the caller of Get.
+func (p *Pool) SetValidate(validate func(io.Closer) bool) {
 	p.lock.Lock()
 	defer p.lock.Unlock()
-	if p.closed {
+	p.setValidate(ignoreContext(validate))
+}
+
+// setValidate sets the validate function read by takeValid, nil for none.
+func (p *Pool) setValidate(validate func(context.Context, io.Closer) bool) {
+	if validate == nil {
+		p.validate.Store(nil)
 		return
 	}
+	p.validate.Store(&validate)
+}
 
-outer:
-	for {
-		select {
-		case item := <-p.pool:
-			_ = item.Close()
-		default:
-			break outer
-		}
+// ignoreContext adapts a validate function which takes no context.
+func ignoreContext(validate func(io.Closer) bool) func(context.Context, io.Closer) bool {
+	if validate == nil {
+		return nil
+	}
+	return func(_ context.Context, item io.Closer) bool {
+		return validate(item)
 	}
 }
 
-// IdleNum return numbers of idle items in the pool.
+// IdleNum return numbers of idle items in the pool. IdleNum, ActiveNum,
+// MaxActive and MaxIdle do not take the pool lock, so they can be used while
+// the pool is frozen.
 func (p *Pool) IdleNum() int {
-	return len(p.pool)
+	return p.idle.len()
+}
+
+// InspectIdle calls fn for each idle item without removing them from the pool,
+// the one put back the longest ago first, or in the order they are handed out
+// with WithExpiryOrder or WithCreationOrder. fn is called after the lock is
+// released, so it may use the pool, but the items may be handed out or closed
+// meanwhile.
+func (p *Pool) InspectIdle(fn func(io.Closer)) {
+	p.lock.RLock()
+	items := p.idle.snapshot()
+	p.lock.RUnlock()
+	for _, it := range items {
+		fn(it.item)
+	}
+}
+
+// IdleAges return for how long each idle item has been idle, in the same order
+// as InspectIdle, for example to tune MaxIdleTime.
+func (p *Pool) IdleAges() []time.Duration {
+	p.lock.RLock()
+	items := p.idle.snapshot()
+	p.lock.RUnlock()
+	now := time.Now()
+	ages := make([]time.Duration, len(items))
+	for i, it := range items {
+		ages[i] = now.Sub(it.returned)
+	}
+	return ages
+}
+
+// ActiveNum return numbers of items currently checked out from the pool. The
+// value is a momentary snapshot and may be stale by the time it is read.
+func (p *Pool) ActiveNum() int {
+	return p.active.len() + p.burst.len()
+}
+
+// Available return how many items can be checked out right now without waiting,
+// the free active slots, or 0 if other callers are waiting for one or the pool
+// is closed. Like ActiveNum, it may be stale by the time it is read. The extra
+// slots of WithBurst are not counted.
+func (p *Pool) Available() int {
+	return p.active.available()
+}
+
+// Utilization return the ratio of ActiveNum to MaxActive, both read at the same
+// time so that a concurrent Resize does not skew it. It is over 1 while items
+// are checked out over maxActive, with WithBurst or after Resize shrank the
+// pool.
+func (p *Pool) Utilization() float64 {
+	used, size := p.active.usage()
+	return float64(used+p.burst.len()) / float64(size)
+}
+
+// MaxActive return the maximum number of items checked out at the same time.
+func (p *Pool) MaxActive() int {
+	return p.active.cap()
+}
+
+// MaxIdle return the maximum number of idle items in the pool, after it was
+// capped at maxActive.
+func (p *Pool) MaxIdle() int {
+	return p.idle.cap()
 }
 
-// Freeze locks the pool so that any other operations will block.
+// Freeze locks the pool so that any other operations will block, though the
+// Get, Put and Release calls already running may still complete. It must be
+// paired with Thaw, and the pool must not be used by the same goroutine in
+// between, including a second Freeze, or it deadlocks. Prefer WithFrozen.
 func (p *Pool) Freeze() {
 	p.lock.Lock()
+	p.frozen.Store(true)
+}
+
+// waitThawed waits until the pool is thawed if it is frozen. Get, Put and
+// Release do not take the lock otherwise.
+func (p *Pool) waitThawed() {
+	if p.frozen.Load() {
+		p.lock.RLock()
+		p.lock.RUnlock()
+	}
+}
+
+// FreezeContext is like Freeze, but gives up when the context is done before
+// the pool could be locked, for example behind a long Fill, and return the
+// context error. The pool must be thawed only if it return nil.
+func (p *Pool) FreezeContext(ctx context.Context) error {
+	locked := make(chan struct{})
+	go func() {
+		p.lock.Lock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		p.frozen.Store(true)
+		return nil
+	case <-ctx.Done():
+		// unlock once the pending Lock goes through
+		go func() {
+			<-locked
+			p.lock.Unlock()
+		}()
+		return ctx.Err()
+	}
 }
 
-// Thaw unlocks the pool.
+// Thaw unlocks the pool frozen by Freeze or FreezeContext. It panics if the
+// pool is not frozen.
 func (p *Pool) Thaw() {
+	if !p.frozen.CompareAndSwap(true, false) {
+		panic("pool: Thaw of unfrozen pool")
+	}
 	p.lock.Unlock()
 }
+
+// WithFrozen runs fn while the pool is frozen, and thaws the pool when fn
+// returns or panics. fn must not use the pool, except for IdleNum, ActiveNum,
+// MaxActive, MaxIdle, IsClosed and Stats.
+func (p *Pool) WithFrozen(fn func()) {
+	p.Freeze()
+	defer p.Thaw()
+	fn()
+}
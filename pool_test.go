@@ -0,0 +1,1782 @@
+package pool
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testItem counts how many times it is closed, and calls onClose if set. Close
+// return err.
+type testItem struct {
+	id      int64
+	closed  atomic.Int32
+	onClose func()
+	err     error
+}
+
+func (t *testItem) Close() error {
+	t.closed.Add(1)
+	if t.onClose != nil {
+		t.onClose()
+	}
+	return t.err
+}
+
+var errTestClose = errors.New("close failed")
+
+// testFactory return a factory creating testItem and the number of items it
+// created.
+func testFactory() (func() (io.Closer, error), *atomic.Int64) {
+	var n atomic.Int64
+	return func() (io.Closer, error) {
+		return &testItem{id: n.Add(1)}, nil
+	}, &n
+}
+
+func TestCloseCallsBackIntoPool(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxIdle int
+		close   func(p *Pool, item io.Closer)
+	}{
+		{"put over maxIdle", 0, func(p *Pool, item io.Closer) { p.Put(item) }},
+		{"clear", 1, func(p *Pool, item io.Closer) { p.Put(item); _ = p.Clear() }},
+		{"close", 1, func(p *Pool, item io.Closer) { p.Put(item); _ = p.Close() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p *Pool
+			called := make(chan struct{}, 1)
+			p, err := New(func() (io.Closer, error) {
+				return &testItem{onClose: func() {
+					p.IdleNum()
+					p.Stats()
+					called <- struct{}{}
+				}}, nil
+			}, 1, tt.maxIdle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			item, err := p.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			go func() {
+				tt.close(p, item)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("deadlock closing an item which calls back into the pool")
+			}
+			<-called
+		})
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	tests := []struct {
+		name     string
+		wait     time.Duration
+		wantSame bool
+	}{
+		{"within lifetime", 0, true},
+		{"past lifetime", 60 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, created := testFactory()
+			p, err := NewWithOptions(factory, WithMaxActive(1), WithMaxIdle(1), WithMaxLifetime(50*time.Millisecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			first, err := p.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.Put(first)
+			time.Sleep(tt.wait)
+			second, err := p.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := second == first; got != tt.wantSame {
+				t.Fatalf("same item = %v, want %v", got, tt.wantSame)
+			}
+			if !tt.wantSame && (first.(*testItem).closed.Load() != 1 || created.Load() != 2) {
+				t.Fatal("expired item not closed and replaced")
+			}
+		})
+	}
+}
+
+// sliceItem cannot be compared nor used as a map key.
+type sliceItem []byte
+
+func (sliceItem) Close() error { return nil }
+
+func TestNotComparableItems(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"default", nil},
+		{"max lifetime", []Option{WithMaxLifetime(time.Hour)}},
+		{"leak detection", []Option{WithLeakDetection(time.Hour)}},
+		{"owner check", []Option{WithOwnerCheck()}},
+		{"creation order", []Option{WithCreationOrder()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithMaxActive(2), WithMaxIdle(2)}, tt.opts...)
+			p, err := NewWithOptions(func() (io.Closer, error) { return sliceItem{1}, nil }, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			item, err := p.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.SetMetadata(item, "md")
+			p.Put(item)
+			if p.IdleNum() != 1 || p.ActiveNum() != 0 {
+				t.Fatalf("idle %d active %d, want 1 0", p.IdleNum(), p.ActiveNum())
+			}
+			if _, err := p.Get(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.ReleaseItem(item); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestPutTwice(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxIdle int
+		put     func(p *Pool, item io.Closer)
+	}{
+		{"put closed", 0, func(p *Pool, item io.Closer) { p.Put(item) }},
+		{"put pooled", 2, func(p *Pool, item io.Closer) { p.Put(item) }},
+		{"put context closed", 0, func(p *Pool, item io.Closer) { _ = p.PutContext(context.Background(), item) }},
+		{"put context pooled", 2, func(p *Pool, item io.Closer) { _ = p.PutContext(context.Background(), item) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, 2, tt.maxIdle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			a, _ := p.Get(context.Background())
+			if _, err := p.Get(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			tt.put(p, a)
+			tt.put(p, a)
+			if n := p.ActiveNum(); n != 1 {
+				t.Fatalf("active = %d after putting one of two items twice, want 1", n)
+			}
+			if n := a.(*testItem).closed.Load(); n > 1 {
+				t.Fatalf("item closed %d times", n)
+			}
+		})
+	}
+}
+
+func TestPutMoreThanCheckedOut(t *testing.T) {
+	factory, _ := testFactory()
+	p, err := New(factory, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	item, _ := p.Get(context.Background())
+	if err := p.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutContext(context.Background(), item); err != ErrOverRelease {
+		t.Fatalf("err = %v, want ErrOverRelease", err)
+	}
+	if item.(*testItem).closed.Load() != 0 || p.IdleNum() != 0 {
+		t.Fatal("item put back after it was released")
+	}
+}
+
+func TestOnReturnContextTimeout(t *testing.T) {
+	tests := []struct {
+		name      string
+		hook      func(ctx context.Context, release <-chan struct{}) error
+		wantPanic bool
+		wantErr   error
+	}{
+		{"blocks past the deadline", func(_ context.Context, release <-chan struct{}) error {
+			<-release
+			return nil
+		}, false, context.DeadlineExceeded},
+		{"panics", func(context.Context, <-chan struct{}) error {
+			panic("reset failed")
+		}, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			hookDone := make(chan struct{})
+			factory, _ := testFactory()
+			p, err := NewWithOptions(factory, WithMaxActive(1), WithMaxIdle(1),
+				WithOnReturnContext(func(ctx context.Context, _ io.Closer) error {
+					defer close(hookDone)
+					return tt.hook(ctx, release)
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			item, _ := p.Get(context.Background())
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			panicked := func() (panicked bool) {
+				defer func() { panicked = recover() != nil }()
+				err = p.PutContext(ctx, item)
+				return false
+			}()
+			if panicked != tt.wantPanic || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, panicked = %v, want %v, %v", err, panicked, tt.wantErr, tt.wantPanic)
+			}
+			if p.ActiveNum() != 0 || p.IdleNum() != 0 {
+				t.Fatalf("active %d idle %d, want 0 0", p.ActiveNum(), p.IdleNum())
+			}
+			if tt.wantPanic {
+				if n := item.(*testItem).closed.Load(); n != 1 {
+					t.Fatalf("item closed %d times after OnReturn panicked, want 1", n)
+				}
+				return
+			}
+			if item.(*testItem).closed.Load() != 0 {
+				t.Fatal("item closed while OnReturn still uses it")
+			}
+			close(release)
+			<-hookDone
+			deadline := time.Now().Add(time.Second)
+			for item.(*testItem).closed.Load() == 0 && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if n := item.(*testItem).closed.Load(); n != 1 {
+				t.Fatalf("item closed %d times once OnReturn returned, want 1", n)
+			}
+		})
+	}
+}
+
+func TestCloseGracefully(t *testing.T) {
+	tests := []struct {
+		name    string
+		putBack bool
+		wantErr error
+	}{
+		{"items returned", true, nil},
+		{"context done first", false, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, 2, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, _ := p.Get(context.Background())
+			b, _ := p.Get(context.Background())
+			if tt.putBack {
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					p.Put(a)
+					p.Put(b)
+				}()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err = p.CloseGracefully(ctx)
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.putBack && time.Since(start) > 100*time.Millisecond {
+				t.Fatal("CloseGracefully did not return once the items were back")
+			}
+			if _, err := p.Get(context.Background()); err != ErrPoolClosed {
+				t.Fatalf("Get after close: %v", err)
+			}
+			if tt.putBack && (a.(*testItem).closed.Load() != 1 || b.(*testItem).closed.Load() != 1) {
+				t.Fatal("returned items not closed")
+			}
+		})
+	}
+}
+
+func TestCloseGracefullyCloseErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  time.Duration
+		hold     bool
+		hang     bool
+		wantErr  []error
+		wantSlow int64
+	}{
+		{"all returned", time.Second, false, false, []error{errTestClose}, 0},
+		{"context done first", 20 * time.Millisecond, true, false, []error{context.DeadlineExceeded}, -1},
+		{"close hangs", 20 * time.Millisecond, true, true, []error{context.DeadlineExceeded}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unblock := make(chan struct{})
+			defer close(unblock)
+			p, err := New(func() (io.Closer, error) {
+				it := &testItem{err: errTestClose}
+				if tt.hang {
+					it.onClose = func() { <-unblock }
+				}
+				return it, nil
+			}, 2, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			idle, _ := p.Get(context.Background())
+			if tt.hold {
+				if _, err := p.Get(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p.Put(idle)
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			start := time.Now()
+			err = p.CloseGracefully(ctx)
+			if elapsed := time.Since(start); elapsed > tt.timeout+100*time.Millisecond {
+				t.Errorf("CloseGracefully took %v past a %v deadline", elapsed, tt.timeout)
+			}
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("err = %v, want it to wrap %v", err, want)
+				}
+			}
+			// the idle item may be closed or abandoned when no time is left
+			if n := p.Stats().SlowCloses; tt.wantSlow >= 0 && n != tt.wantSlow {
+				t.Errorf("%d closes abandoned, want %d", n, tt.wantSlow)
+			}
+		})
+	}
+}
+
+func BenchmarkGetPut(b *testing.B) {
+	for _, maxActive := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("maxActive=%d", maxActive), func(b *testing.B) {
+			factory, _ := testFactory()
+			p, err := New(factory, maxActive, maxActive)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer p.Close()
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					item, err := p.Get(ctx)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					p.Put(item)
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkGetPutWithWriter runs Get and Put while another goroutine takes the
+// pool lock in a loop with Resize, which Get and Put must not wait for. The
+// spin row busy loops instead, for the same CPU time without the lock.
+func BenchmarkGetPutWithWriter(b *testing.B) {
+	var spun atomic.Int64
+	writers := []struct {
+		name  string
+		write func(p *Pool)
+	}{
+		{"none", nil},
+		{"spin", func(*Pool) { spun.Add(1) }},
+		{"Resize", func(p *Pool) { _ = p.Resize(16, 16) }},
+	}
+	for _, w := range writers {
+		b.Run(w.name, func(b *testing.B) {
+			factory, _ := testFactory()
+			p, err := New(factory, 16, 16)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer p.Close()
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for w.write != nil {
+					select {
+					case <-stop:
+						return
+					default:
+						w.write(p)
+					}
+				}
+			}()
+			ctx := context.Background()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					item, err := p.Get(ctx)
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					p.Put(item)
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// BenchmarkGetPutReadingStats runs Get and Put while other goroutines read
+// Stats in a loop. The spin rows busy loop instead, without touching the pool,
+// so that they take the same CPU time: the Stats rows should not be slower.
+func BenchmarkGetPutReadingStats(b *testing.B) {
+	var spun atomic.Int64
+	reads := []struct {
+		name string
+		read func(p *Pool)
+	}{
+		{"spin", func(*Pool) { spun.Add(1) }},
+		{"Stats", func(p *Pool) { _ = p.Stats() }},
+	}
+	for _, readers := range []int{0, 1, 4} {
+		for _, r := range reads {
+			if readers == 0 && r.name != "spin" {
+				continue
+			}
+			b.Run(fmt.Sprintf("readers=%d/%s", readers, r.name), func(b *testing.B) {
+				benchmarkGetPutReading(b, readers, r.read)
+			})
+		}
+	}
+}
+
+func benchmarkGetPutReading(b *testing.B, readers int, read func(p *Pool)) {
+	factory, _ := testFactory()
+	p, err := New(factory, 16, 16)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					read(p)
+				}
+			}
+		}()
+	}
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			item, err := p.Get(ctx)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			p.Put(item)
+		}
+	})
+	b.StopTimer()
+	close(stop)
+	wg.Wait()
+	if s := p.Stats(); s.Gets != s.Puts {
+		b.Fatalf("%d gets and %d puts", s.Gets, s.Puts)
+	}
+}
+
+func BenchmarkStats(b *testing.B) {
+	factory, _ := testFactory()
+	p, err := New(factory, 16, 16)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer p.Close()
+	p.Fill()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = p.Stats()
+		}
+	})
+}
+
+func TestFactoryErrorRestoresSlot(t *testing.T) {
+	errDial := errors.New("dial failed")
+	tests := []struct {
+		name     string
+		failures int
+	}{
+		{"one failure", 1},
+		{"a few failures", 3},
+		{"more failures than maxActive", 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			p, err := New(func() (io.Closer, error) {
+				if calls++; calls <= tt.failures {
+					return nil, errDial
+				}
+				return &testItem{}, nil
+			}, 2, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			for i := 0; i < tt.failures; i++ {
+				if _, err := p.Get(context.Background()); !errors.Is(err, errDial) {
+					t.Fatalf("Get %d: err = %v, want %v", i, err, errDial)
+				}
+				if n := p.ActiveNum(); n != 0 {
+					t.Fatalf("active = %d after a failed creation, want 0", n)
+				}
+			}
+			for i := 0; i < 2; i++ {
+				if _, err := p.TryGet(); err != nil {
+					t.Fatalf("TryGet %d after the failures: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrentGetPutReleaseClose(t *testing.T) {
+	tests := []struct {
+		name               string
+		maxActive, maxIdle int
+	}{
+		{"no idle items", 4, 0},
+		{"idle items", 4, 2},
+		{"single slot", 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, tt.maxActive, tt.maxIdle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 200; j++ {
+						ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+						item, err := p.Get(ctx)
+						cancel()
+						if err != nil {
+							continue
+						}
+						if (i+j)%3 == 0 {
+							_ = p.Release()
+						} else {
+							p.Put(item)
+						}
+						if n := p.ActiveNum(); n < 0 || n > tt.maxActive {
+							t.Errorf("active = %d, want between 0 and %d", n, tt.maxActive)
+						}
+					}
+				}(i)
+			}
+			time.Sleep(5 * time.Millisecond)
+			if err := p.Close(); err != nil {
+				t.Error(err)
+			}
+			wg.Wait()
+			if s := p.Stats(); s.Active < 0 || s.Idle != 0 {
+				t.Fatalf("active %d idle %d after close", s.Active, s.Idle)
+			}
+		})
+	}
+}
+
+func TestResizeWithGetsInFlight(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int
+	}{
+		{"5 to 20", 5, 20},
+		{"1 to 2", 1, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, tt.from, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			var held []io.Closer
+			for i := 0; i < tt.from; i++ {
+				item, _ := p.Get(context.Background())
+				held = append(held, item)
+			}
+			// Gets blocked on the old limit
+			got := make(chan io.Closer)
+			for i := tt.from; i < tt.to; i++ {
+				go func() {
+					item, err := p.Get(context.Background())
+					if err != nil {
+						t.Error(err)
+					}
+					got <- item
+				}()
+			}
+			if err := p.Resize(tt.to, tt.to); err != nil {
+				t.Fatal(err)
+			}
+			for i := tt.from; i < tt.to; i++ {
+				select {
+				case item := <-got:
+					held = append(held, item)
+				case <-time.After(time.Second):
+					t.Fatalf("only %d Gets served after growing to %d", i-tt.from, tt.to)
+				}
+			}
+			if _, err := p.TryGet(); err != ErrPoolExhausted {
+				t.Fatalf("TryGet over the new limit: %v", err)
+			}
+			for _, item := range held {
+				p.Put(item)
+			}
+			if p.ActiveNum() != 0 || p.IdleNum() != tt.to {
+				t.Fatalf("active %d idle %d, want 0 %d", p.ActiveNum(), p.IdleNum(), tt.to)
+			}
+		})
+	}
+}
+
+func TestResizeShrinksIdle(t *testing.T) {
+	factory, created := testFactory()
+	p, err := New(factory, 20, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.FillTo(20); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Resize(5, 5); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.IdleNum(); n != 5 {
+		t.Fatalf("idle = %d, want 5", n)
+	}
+	if closed := p.Stats().Closed; closed != created.Load()-5 {
+		t.Fatalf("closed %d of %d items, want all but 5", closed, created.Load())
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	factory, _ := testFactory()
+	closed, err := New(factory, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, _ := closed.Get(context.Background())
+	_ = closed.Close()
+
+	tests := []struct {
+		name string
+		call func() error
+		want error
+	}{
+		{"New max active", func() error { _, err := New(factory, 0, 0); return err }, ErrInvalidMaxActive},
+		{"New max idle", func() error { _, err := New(factory, 1, -1); return err }, ErrInvalidMaxIdle},
+		{"Get", func() error { _, err := closed.Get(context.Background()); return err }, ErrPoolClosed},
+		{"TryGet", func() error { _, err := closed.TryGet(); return err }, ErrPoolClosed},
+		{"Release", closed.Release, ErrPoolClosed},
+		{"PutContext", func() error { return closed.PutContext(context.Background(), held) }, ErrPoolClosed},
+		{"Resize", func() error { return closed.Resize(2, 2) }, ErrPoolClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFactoryPanic(t *testing.T) {
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{"string", "dial setup", "dial setup"},
+		{"error", errors.New("nil dialer"), "nil dialer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			p, err := New(func() (io.Closer, error) {
+				if calls++; calls == 3 {
+					panic(tt.value)
+				}
+				return &testItem{}, nil
+			}, 2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			for i := 0; i < 2; i++ {
+				item, err := p.Get(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Put(item)
+			}
+			_, err = p.Get(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want the panic value %q", err, tt.want)
+			}
+			for i := 0; i < 2; i++ {
+				if _, err := p.TryGet(); err != nil {
+					t.Fatalf("TryGet %d after the panic: %v", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxUsage(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxUsage    int
+		rounds      int
+		wantCreated int64
+	}{
+		{"unlimited", 0, 10, 1},
+		{"cap of 1", 1, 3, 3},
+		{"cap of 3", 3, 7, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, created := testFactory()
+			p, err := NewWithOptions(factory, WithMaxActive(1), WithMaxIdle(1), WithMaxUsage(tt.maxUsage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			for i := 0; i < tt.rounds; i++ {
+				item, err := p.Get(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Put(item)
+			}
+			if n := created.Load(); n != tt.wantCreated {
+				t.Fatalf("created %d items, want %d", n, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	tests := []struct {
+		name        string
+		waitTimeout time.Duration
+		ctxTimeout  time.Duration // 0 for context.Background()
+		want        time.Duration
+	}{
+		{"background context", 30 * time.Millisecond, 0, 30 * time.Millisecond},
+		{"shorter caller deadline", time.Second, 30 * time.Millisecond, 30 * time.Millisecond},
+		{"shorter pool timeout", 30 * time.Millisecond, time.Second, 30 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := NewWithOptions(factory, WithMaxActive(1), WithWaitTimeout(tt.waitTimeout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if _, err := p.Get(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if tt.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.ctxTimeout)
+				defer cancel()
+			}
+			start := time.Now()
+			_, err = p.Get(ctx)
+			waited := time.Since(start)
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("err = %v, want context.DeadlineExceeded", err)
+			}
+			if waited < tt.want || waited > tt.want+200*time.Millisecond {
+				t.Fatalf("waited %v, want about %v", waited, tt.want)
+			}
+		})
+	}
+}
+
+func TestCloserFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"nil error", nil},
+		{"error", errTestClose},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			c := CloserFunc(func() error {
+				calls++
+				return tt.err
+			})
+			for i := 0; i < 3; i++ {
+				if err := c.Close(); err != tt.err {
+					t.Fatalf("Close %d: err = %v, want %v", i, err, tt.err)
+				}
+			}
+			if calls != 1 {
+				t.Fatalf("f called %d times, want 1", calls)
+			}
+		})
+	}
+}
+
+func TestNopCloser(t *testing.T) {
+	var buf bytes.Buffer
+	p, err := New(func() (io.Closer, error) { return NopCloser(&buf), nil }, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	item, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	nop := item.(*Nop[*bytes.Buffer])
+	if nop.Value != &buf {
+		t.Fatal("NopCloser did not keep the value")
+	}
+	if err := nop.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAutoscalerKeepsMaxIdle(t *testing.T) {
+	tests := []struct {
+		name        string
+		sizes       []int
+		wantMaxIdle int
+	}{
+		{"shrink below maxIdle", []int{2}, 2},
+		{"shrink then grow", []int{2, 10}, 8},
+		{"grow", []int{20}, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, 10, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			var calls atomic.Int32
+			done := make(chan struct{})
+			p.StartAutoscaler(time.Millisecond, func(Stats) int {
+				i := int(calls.Add(1)) - 1
+				if i == len(tt.sizes) {
+					close(done)
+				}
+				if i >= len(tt.sizes) {
+					return 0
+				}
+				return tt.sizes[i]
+			})
+			<-done
+			if s := p.Stats(); s.MaxActive != tt.sizes[len(tt.sizes)-1] || s.MaxIdle != tt.wantMaxIdle {
+				t.Fatalf("max active %d max idle %d, want %d %d", s.MaxActive, s.MaxIdle, tt.sizes[len(tt.sizes)-1], tt.wantMaxIdle)
+			}
+		})
+	}
+}
+
+func TestIdleOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want int // index in creation order of the item handed out first
+	}{
+		{"fifo", nil, 1},
+		{"lifo", []Option{WithLIFO()}, 2},
+		{"expiry", []Option{WithExpiryOrder(), WithMaxLifetime(time.Hour)}, 0},
+		{"creation", []Option{WithCreationOrder()}, 0},
+		{"creation over lifo", []Option{WithCreationOrder(), WithLIFO()}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			opts := append([]Option{WithMaxActive(3), WithMaxIdle(3)}, tt.opts...)
+			p, err := NewWithOptions(factory, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			var items []io.Closer
+			for i := 0; i < 3; i++ {
+				item, err := p.Get(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				items = append(items, item)
+				time.Sleep(time.Millisecond)
+			}
+			// returned: second, first, third
+			for _, i := range []int{1, 0, 2} {
+				p.Put(items[i])
+			}
+			item, err := p.Get(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if item != items[tt.want] {
+				t.Fatalf("got item %d, want item %d", item.(*testItem).id-1, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntrospectionWhileFrozen(t *testing.T) {
+	factory, _ := testFactory()
+	p, err := New(factory, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if err := p.FillTo(1); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		get  func() int
+		want int
+	}{
+		{"IdleNum", p.IdleNum, 1},
+		{"ActiveNum", p.ActiveNum, 0},
+		{"Stats", func() int { return p.Stats().Idle }, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(chan int)
+			go func() {
+				p.Freeze()
+				defer p.Thaw()
+				got <- tt.get()
+			}()
+			select {
+			case n := <-got:
+				if n != tt.want {
+					t.Fatalf("got %d, want %d", n, tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("blocked while the pool is frozen by the same goroutine")
+			}
+		})
+	}
+}
+
+func TestIntrospectionRacingClose(t *testing.T) {
+	factory, _ := testFactory()
+	p, err := New(factory, 4, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.FillTo(4); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			if p.IdleNum() < 0 || p.ActiveNum() < 0 {
+				t.Error("negative count")
+			}
+		}
+	}()
+	_ = p.Close()
+	<-done
+}
+
+func TestWaitForIdleOutcomes(t *testing.T) {
+	tests := []struct {
+		name    string
+		free    bool // a slot is free from the start
+		putBack bool
+		close   bool
+		want    error
+	}{
+		{"slot free", true, false, false, nil},
+		{"item returned", false, true, false, nil},
+		{"context done", false, false, false, context.DeadlineExceeded},
+		{"pool closed", false, false, true, ErrPoolClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, 1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if !tt.free {
+				item, _ := p.Get(context.Background())
+				go func() {
+					time.Sleep(10 * time.Millisecond)
+					if tt.putBack {
+						p.Put(item)
+					}
+					if tt.close {
+						_ = p.Close()
+					}
+				}()
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if err := p.WaitForIdle(ctx); !errors.Is(err, tt.want) || (tt.want == nil && err != nil) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && p.ActiveNum() != 0 {
+				t.Fatal("WaitForIdle took a slot")
+			}
+		})
+	}
+}
+
+// waiters return the number of callers waiting for an active slot.
+func waiters(p *Pool) int {
+	p.active.mu.Lock()
+	defer p.active.mu.Unlock()
+	return len(p.active.waiters)
+}
+
+func TestWaitersServedInOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		prios []int
+		want  []int // indexes in arrival order
+	}{
+		{"same priority", []int{0, 0, 0, 0, 0}, []int{0, 1, 2, 3, 4}},
+		{"higher priority first", []int{0, 1, 0, 2, 1}, []int{3, 1, 4, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, 1, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			held, _ := p.Get(context.Background())
+			served := make(chan int, len(tt.prios))
+			for i, prio := range tt.prios {
+				go func() {
+					item, err := p.GetPriority(context.Background(), prio)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					served <- i
+					p.Put(item)
+				}()
+				for waiters(p) != i+1 {
+					time.Sleep(time.Millisecond)
+				}
+			}
+			p.Put(held)
+			for _, want := range tt.want {
+				if got := <-served; got != want {
+					t.Fatalf("served waiter %d, want %d", got, want)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSaturatedGet reports the p99 and the longest wait of Get on a
+// saturated pool, and of a buffered channel used as a semaphore, which serves its blocked senders
+// in no particular order.
+func BenchmarkSaturatedGet(b *testing.B) {
+	const goroutines = 64
+	run := func(b *testing.B, acquire func() (release func())) {
+		var mu sync.Mutex
+		var waits []time.Duration
+		b.SetParallelism(goroutines)
+		b.RunParallel(func(pb *testing.PB) {
+			var local []time.Duration
+			for pb.Next() {
+				start := time.Now()
+				release := acquire()
+				local = append(local, time.Since(start))
+				release()
+			}
+			mu.Lock()
+			waits = append(waits, local...)
+			mu.Unlock()
+		})
+		sort.Slice(waits, func(i, j int) bool { return waits[i] < waits[j] })
+		if len(waits) > 0 {
+			b.ReportMetric(float64(waits[len(waits)*99/100].Nanoseconds()), "p99-ns")
+			b.ReportMetric(float64(waits[len(waits)-1].Nanoseconds()), "max-ns")
+		}
+	}
+	b.Run("pool", func(b *testing.B) {
+		factory, _ := testFactory()
+		p, err := New(factory, 4, 4)
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer p.Close()
+		run(b, func() func() {
+			item, err := p.Get(context.Background())
+			if err != nil {
+				b.Error(err)
+				return func() {}
+			}
+			return func() { p.Put(item) }
+		})
+	})
+	b.Run("channel", func(b *testing.B) {
+		slots := make(chan struct{}, 4)
+		run(b, func() func() {
+			slots <- struct{}{}
+			return func() { <-slots }
+		})
+	})
+}
+
+func TestLimiterMode(t *testing.T) {
+	tests := []struct {
+		name string
+		put  func(p *Pool, item io.Closer)
+	}{
+		{"Put", func(p *Pool, item io.Closer) { p.Put(item) }},
+		{"PutContext", func(p *Pool, item io.Closer) { _ = p.PutContext(context.Background(), item) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, created := testFactory()
+			p, err := New(factory, 2, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			p.Fill()
+			for i := 1; i <= 3; i++ {
+				item, err := p.Get(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				tt.put(p, item)
+				if item.(*testItem).closed.Load() != 1 {
+					t.Fatal("item not closed on put")
+				}
+				if n := created.Load(); n != int64(i) {
+					t.Fatalf("created %d items after %d Gets", n, i)
+				}
+				if n := p.IdleNum(); n != 0 {
+					t.Fatalf("idle = %d, want 0", n)
+				}
+			}
+		})
+	}
+}
+
+func TestPrefilledPoolCreatesNothing(t *testing.T) {
+	for _, maxActive := range []int{4, 16, 64} {
+		t.Run(fmt.Sprintf("maxActive=%d", maxActive), func(t *testing.T) {
+			factory, created := testFactory()
+			p, err := New(factory, maxActive, maxActive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if err := p.FillTo(maxActive); err != nil {
+				t.Fatal(err)
+			}
+			filled := created.Load()
+			start := make(chan struct{})
+			items := make(chan io.Closer, maxActive)
+			var wg sync.WaitGroup
+			for i := 0; i < maxActive; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					item, err := p.Get(context.Background())
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					items <- item
+				}()
+			}
+			close(start)
+			wg.Wait()
+			if n := created.Load() - filled; n != 0 {
+				t.Fatalf("%d items created with %d idle ones", n, maxActive)
+			}
+			close(items)
+			for item := range items {
+				p.Put(item)
+			}
+		})
+	}
+}
+
+func TestUseOutcomes(t *testing.T) {
+	errUse := errors.New("query failed")
+	tests := []struct {
+		name       string
+		opts       []Option
+		fn         func(io.Closer) error
+		wantErr    error
+		wantPanic  bool
+		wantPooled bool
+	}{
+		{"success", nil, func(io.Closer) error { return nil }, nil, false, true},
+		{"error discards", nil, func(io.Closer) error { return errUse }, errUse, false, false},
+		{"error kept", []Option{WithKeepOnError()}, func(io.Closer) error { return errUse }, errUse, false, true},
+		{"panic discards", []Option{WithKeepOnError()}, func(io.Closer) error { panic("boom") }, nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			opts := append([]Option{WithMaxActive(1), WithMaxIdle(1)}, tt.opts...)
+			p, err := NewWithOptions(factory, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			var used io.Closer
+			var panicked bool
+			func() {
+				defer func() { panicked = recover() != nil }()
+				err = p.Use(context.Background(), func(item io.Closer) error {
+					used = item
+					return tt.fn(item)
+				})
+			}()
+			if panicked != tt.wantPanic || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, panicked = %v, want %v, %v", err, panicked, tt.wantErr, tt.wantPanic)
+			}
+			if n := p.ActiveNum(); n != 0 {
+				t.Fatalf("active = %d after Use, want 0", n)
+			}
+			pooled := p.IdleNum() == 1
+			closed := used.(*testItem).closed.Load() == 1
+			if pooled != tt.wantPooled || closed == pooled {
+				t.Fatalf("pooled = %v, want %v", pooled, tt.wantPooled)
+			}
+		})
+	}
+}
+
+func TestClearAndCloseInterleaved(t *testing.T) {
+	tests := []struct {
+		name       string
+		clearFirst bool
+	}{
+		{"close during clear", true},
+		{"clear during close", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var items []*testItem
+			var mu sync.Mutex
+			p, err := New(func() (io.Closer, error) {
+				item := &testItem{onClose: func() { time.Sleep(time.Millisecond) }}
+				mu.Lock()
+				items = append(items, item)
+				mu.Unlock()
+				return item, nil
+			}, 50, 50)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.FillTo(50); err != nil {
+				t.Fatal(err)
+			}
+			first, second := p.Clear, p.Close
+			if !tt.clearFirst {
+				first, second = p.Close, p.Clear
+			}
+			done := make(chan struct{})
+			go func() {
+				_ = first()
+				close(done)
+			}()
+			time.Sleep(5 * time.Millisecond)
+			_ = second()
+			<-done
+			_ = p.Close()
+			for i, item := range items {
+				if n := item.closed.Load(); n != 1 {
+					t.Fatalf("item %d closed %d times", i, n)
+				}
+			}
+		})
+	}
+}
+
+// resettable counts the calls to Reset.
+type resettable struct {
+	testItem
+	resets atomic.Int32
+}
+
+func (r *resettable) Reset() { r.resets.Add(1) }
+
+func TestResetOnlyWhenPooled(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		fill       bool // no room is left in the pool
+		put        func(p *Pool, item io.Closer)
+		wantResets int32
+	}{
+		{"pooled by Put", nil, false, func(p *Pool, item io.Closer) { p.Put(item) }, 1},
+		{"pooled by PutContext", nil, false, func(p *Pool, item io.Closer) { _ = p.PutContext(context.Background(), item) }, 1},
+		{"closed on overflow", nil, true, func(p *Pool, item io.Closer) { p.Put(item) }, 0},
+		{"put grace over", []Option{WithPutGrace(10 * time.Millisecond)}, true, func(p *Pool, item io.Closer) { p.Put(item) }, 0},
+		{"put context done", nil, true, func(p *Pool, item io.Closer) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			_ = p.PutContext(ctx, item)
+		}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithMaxActive(2), WithMaxIdle(1)}, tt.opts...)
+			p, err := NewWithOptions(func() (io.Closer, error) { return &resettable{}, nil }, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			item, _ := p.Get(context.Background())
+			if tt.fill {
+				other, _ := p.Get(context.Background())
+				p.Put(other)
+			}
+			tt.put(p, item)
+			r := item.(*resettable)
+			if n := r.resets.Load(); n != tt.wantResets {
+				t.Fatalf("Reset called %d times, want %d", n, tt.wantResets)
+			}
+			if pooled := r.closed.Load() == 0; pooled != (tt.wantResets == 1) {
+				t.Fatalf("pooled = %v with %d resets", pooled, tt.wantResets)
+			}
+		})
+	}
+}
+
+func TestCancelledContextTakesNoSlot(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name string
+		get  func(p *Pool) (io.Closer, error)
+	}{
+		{"Get", func(p *Pool) (io.Closer, error) { return p.Get(cancelled) }},
+		{"GetPriority", func(p *Pool) (io.Closer, error) { return p.GetPriority(cancelled, 1) }},
+		{"GetFresh", func(p *Pool) (io.Closer, error) { return p.GetFresh(cancelled) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, created := testFactory()
+			p, err := New(factory, 2, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			p.Fill()
+			filled := created.Load()
+			for i := 0; i < 100; i++ {
+				item, err := tt.get(p)
+				if item != nil || !errors.Is(err, context.Canceled) {
+					t.Fatalf("got %v, %v, want no item and context.Canceled", item, err)
+				}
+			}
+			if p.ActiveNum() != 0 || p.IdleNum() != 2 || created.Load() != filled {
+				t.Fatalf("active %d idle %d created %d", p.ActiveNum(), p.IdleNum(), created.Load()-filled)
+			}
+		})
+	}
+}
+
+func TestNilItems(t *testing.T) {
+	p, err := New(func() (io.Closer, error) { return nil, nil }, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"Get", func() error { _, err := p.Get(context.Background()); return err }},
+		{"TryGet", func() error { _, err := p.TryGet(); return err }},
+		{"PutContext", func() error { return p.PutContext(context.Background(), nil) }},
+		{"Discard", func() error { return p.Discard(nil) }},
+		{"ReleaseItem", func() error { return p.ReleaseItem(nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, ErrNilItem) {
+				t.Fatalf("err = %v, want ErrNilItem", err)
+			}
+			p.Put(nil)
+			if p.ActiveNum() != 0 || p.IdleNum() != 0 {
+				t.Fatalf("active %d idle %d, want 0 0", p.ActiveNum(), p.IdleNum())
+			}
+		})
+	}
+}
+
+func TestRefreshCount(t *testing.T) {
+	tests := []struct {
+		name string
+		idle int
+		n    int
+		want int
+	}{
+		{"default replaces idle", 3, 0, 3},
+		{"default keeps MinIdle", 0, 0, 1},
+		{"fewer than idle", 3, 2, 2},
+		{"capped at maxIdle", 1, 10, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, created := testFactory()
+			p, err := NewWithOptions(factory, WithMaxActive(4), WithMaxIdle(3), WithMinIdle(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			var stale []io.Closer
+			for i := 0; i < tt.idle; i++ {
+				item, _ := p.Get(context.Background())
+				stale = append(stale, item)
+			}
+			for _, item := range stale {
+				p.Put(item)
+			}
+			before := created.Load()
+			if err := p.Refresh(tt.n); err != nil {
+				t.Fatal(err)
+			}
+			if got := created.Load() - before; got != int64(tt.want) || p.IdleNum() != tt.want {
+				t.Fatalf("created %d idle %d, want %d", got, p.IdleNum(), tt.want)
+			}
+			for _, item := range stale {
+				if item.(*testItem).closed.Load() == 0 {
+					t.Fatalf("stale item %d not closed", item.(*testItem).id)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateOutcomes(t *testing.T) {
+	tests := []struct {
+		name       string
+		validate   func(ctx context.Context) bool
+		wantPanic  bool
+		wantErr    error
+		wantIdle   int
+		wantClosed int
+	}{
+		{"valid", func(context.Context) bool { return true }, false, nil, 5, 0},
+		{"invalid", func(context.Context) bool { return false }, false, nil, 1, 5},
+		{"past the deadline", func(context.Context) bool {
+			time.Sleep(30 * time.Millisecond)
+			return true
+		}, false, context.DeadlineExceeded, 4, 1},
+		{"panics", func(context.Context) bool { panic("validate failed") }, true, nil, 4, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var closed atomic.Int64
+			var created atomic.Int64
+			var checking atomic.Bool
+			p, err := NewWithOptions(func() (io.Closer, error) {
+				created.Add(1)
+				return &testItem{onClose: func() { closed.Add(1) }}, nil
+			}, WithMaxActive(5), WithValidateContext(func(ctx context.Context, _ io.Closer) bool {
+				return !checking.Load() || tt.validate(ctx)
+			}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			p.Fill()
+			checking.Store(true)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			var item io.Closer
+			panicked := func() (panicked bool) {
+				defer func() { panicked = recover() != nil }()
+				item, err = p.Get(ctx)
+				return false
+			}()
+			checking.Store(false)
+			if panicked != tt.wantPanic || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, panicked = %v, want %v, %v", err, panicked, tt.wantErr, tt.wantPanic)
+			}
+			if item != nil {
+				p.Put(item)
+			}
+			// the abandoned validate returns later
+			time.Sleep(40 * time.Millisecond)
+			if p.ActiveNum() != 0 || p.IdleNum() != tt.wantIdle || closed.Load() != int64(tt.wantClosed) {
+				t.Fatalf("active %d idle %d closed %d, want 0 %d %d", p.ActiveNum(), p.IdleNum(), closed.Load(), tt.wantIdle, tt.wantClosed)
+			}
+			if want := int64(tt.wantIdle + tt.wantClosed); created.Load() != want {
+				t.Fatalf("created %d items, want %d", created.Load(), want)
+			}
+		})
+	}
+}
+
+func TestResizeWhileInUse(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"untracked", nil},
+		{"max lifetime", []Option{WithMaxLifetime(time.Hour)}},
+		{"leak detection", []Option{WithLeakDetection(time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := NewWithOptions(factory, append([]Option{WithMaxActive(4)}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						item, err := p.GetWithTimeout(time.Second)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						p.Put(item)
+					}
+				}()
+			}
+			for start, i := time.Now(), 0; time.Since(start) < 100*time.Millisecond; i++ {
+				if err := p.Resize(1+i%4, 1+i%4); err != nil {
+					t.Fatal(err)
+				}
+				p.SetMaxLifetime(time.Duration(1+i%2) * time.Hour)
+			}
+			close(stop)
+			wg.Wait()
+			if p.ActiveNum() != 0 {
+				t.Fatalf("active %d after the Gets returned", p.ActiveNum())
+			}
+		})
+	}
+}
+
+func TestTryGetNeverWaits(t *testing.T) {
+	errDial := errors.New("dial failed")
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{"max creating", []Option{WithMaxCreating(1)}, ErrPoolExhausted},
+		{"coalesce creation", []Option{WithCoalesceCreation()}, ErrPoolExhausted},
+		{"retries", []Option{WithGetRetries(3, time.Second)}, errDial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creating := make(chan struct{})
+			release := make(chan struct{})
+			var calls atomic.Int32
+			p, err := NewWithOptions(func() (io.Closer, error) {
+				if calls.Add(1) == 1 {
+					close(creating)
+					<-release
+				}
+				return nil, errDial
+			}, append([]Option{WithMaxActive(2)}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			go p.Get(context.Background())
+			<-creating
+			start := time.Now()
+			item, err := p.TryGet()
+			if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+				t.Fatalf("TryGet waited %v", elapsed)
+			}
+			close(release)
+			if item != nil || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, %v, want %v", item, err, tt.wantErr)
+			}
+			if p.Available() == 0 {
+				t.Fatal("TryGet kept its slot")
+			}
+		})
+	}
+}
+
+func TestPauseWithQueuedGets(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{"fail", nil, ErrPoolPaused},
+		{"wait for Resume", []Option{WithPauseWait()}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := NewWithOptions(factory, append([]Option{WithMaxActive(1)}, tt.opts...)...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			held, _ := p.Get(context.Background())
+			const queued = 3
+			errs := make(chan error, queued)
+			for i := 0; i < queued; i++ {
+				go func() {
+					item, err := p.GetWithTimeout(time.Second)
+					if err == nil {
+						p.Put(item)
+					}
+					errs <- err
+				}()
+			}
+			for waiters(p) < queued {
+				time.Sleep(time.Millisecond)
+			}
+			p.Pause()
+			p.Put(held)
+			time.Sleep(20 * time.Millisecond)
+			if p.ActiveNum() != 0 || p.IdleNum() != 1 {
+				t.Fatalf("active %d idle %d while paused, want 0 1", p.ActiveNum(), p.IdleNum())
+			}
+			p.Resume()
+			for i := 0; i < queued; i++ {
+				if err := <-errs; !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+func TestFreezeBlocksGetPutRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(p *Pool, item io.Closer)
+	}{
+		{"Get", func(p *Pool, _ io.Closer) { _, _ = p.Get(context.Background()) }},
+		{"TryGet", func(p *Pool, _ io.Closer) { _, _ = p.TryGet() }},
+		{"Put", func(p *Pool, item io.Closer) { p.Put(item) }},
+		{"PutContext", func(p *Pool, item io.Closer) { _ = p.PutContext(context.Background(), item) }},
+		{"Release", func(p *Pool, _ io.Closer) { _ = p.Release() }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory, _ := testFactory()
+			p, err := New(factory, 2, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			item, _ := p.Get(context.Background())
+			p.Freeze()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tt.call(p, item)
+			}()
+			select {
+			case <-done:
+				p.Thaw()
+				t.Fatal("returned while the pool is frozen")
+			case <-time.After(20 * time.Millisecond):
+			}
+			p.Thaw()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("still blocked after Thaw")
+			}
+		})
+	}
+}
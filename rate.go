@@ -0,0 +1,75 @@
+package pool
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultFailureWindow is the window of CreationFailureRate without
+// WithFailureWindow.
+const defaultFailureWindow = time.Minute
+
+// rateBuckets is the number of buckets the failure window is split into.
+const rateBuckets = 10
+
+// failureRate counts the successful and failed factory calls over a sliding
+// window, split into buckets so that old calls drop out gradually.
+type failureRate struct {
+	mu      sync.Mutex
+	width   time.Duration // of a bucket
+	buckets [rateBuckets]rateBucket
+}
+
+type rateBucket struct {
+	slot   int64 // start of the bucket in units of width
+	ok     int64
+	failed int64
+}
+
+func newFailureRate(window time.Duration) *failureRate {
+	if window <= 0 {
+		window = defaultFailureWindow
+	}
+	return &failureRate{width: max(window/rateBuckets, 1)}
+}
+
+// record counts a factory call.
+func (r *failureRate) record(ok bool) {
+	slot := time.Now().UnixNano() / int64(r.width)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := &r.buckets[slot%rateBuckets]
+	if b.slot != slot {
+		*b = rateBucket{slot: slot}
+	}
+	if ok {
+		b.ok++
+	} else {
+		b.failed++
+	}
+}
+
+// rate return the share of failed calls in the window, 0 without calls.
+func (r *failureRate) rate() float64 {
+	slot := time.Now().UnixNano() / int64(r.width)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ok, failed int64
+	for _, b := range r.buckets {
+		if b.slot > slot-rateBuckets {
+			ok += b.ok
+			failed += b.failed
+		}
+	}
+	if ok+failed == 0 {
+		return 0
+	}
+	return float64(failed) / float64(ok+failed)
+}
+
+// CreationFailureRate return the share of factory calls which failed over the
+// window given to WithFailureWindow, one minute by default. Calls failing due
+// to the caller context are not counted.
+func (p *Pool) CreationFailureRate() float64 {
+	return p.failures.rate()
+}
@@ -0,0 +1,132 @@
+package pool
+
+import (
+	"context"
+	"time"
+)
+
+// SetMinIdle sets the number of idle items the reaper keeps in the pool. It is
+// capped at maxIdle.
+func (p *Pool) SetMinIdle(n int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.minIdle = n
+}
+
+// StartReaper starts a background goroutine which closes expired idle items
+// and tops up the pool to MinIdle every interval. Calling it again replaces the
+// running reaper. The reaper stops when the pool is closed.
+func (p *Pool) StartReaper(interval time.Duration) {
+	p.runEvery(&p.stopReaper, interval, p.reap)
+}
+
+// StartHealthCheck starts a background goroutine which runs the HealthCheck
+// hook on every idle item every interval. Unhealthy items are closed and
+// replaced by new ones, up to maxIdle. Calling it again replaces the running
+// health check. It stops when the pool is closed. Items which are not
+// comparable are not checked.
+func (p *Pool) StartHealthCheck(interval time.Duration) {
+	if p.healthCheck == nil {
+		return
+	}
+	p.runEvery(&p.stopHealthCheck, interval, p.checkHealth)
+}
+
+// runEvery starts a goroutine calling fn every interval until the pool is
+// closed or stop is closed. A goroutine already started with stop is stopped.
+func (p *Pool) runEvery(stop *chan struct{}, interval time.Duration, fn func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed.Load() {
+		return
+	}
+	if *stop != nil {
+		close(*stop)
+	}
+	s, done := make(chan struct{}), p.done
+	*stop = s
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s:
+				return
+			case <-done:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+}
+
+// reap closes expired idle items and creates new ones up to minIdle. The
+// factory is called without holding the lock.
+func (p *Pool) reap() {
+	p.lock.Lock()
+	if p.closed.Load() {
+		p.lock.Unlock()
+		return
+	}
+	expired := p.idle.evictExpired(time.Now())
+	need := min(p.minIdle, p.maxIdle) - p.idle.len()
+	p.lock.Unlock()
+
+	for _, it := range expired {
+		p.closeItem(it.item, ReasonExpired)
+	}
+
+	p.topUp(need)
+}
+
+// checkHealth runs the health check on the idle items one at a time, so that
+// borrowers can still get the other ones meanwhile. Each checked item is put
+// back as if it was just returned, so that the order is the same after a full
+// sweep. Items handed out during the sweep are skipped.
+func (p *Pool) checkHealth() {
+	p.lock.RLock()
+	items := p.idle.snapshot()
+	p.lock.RUnlock()
+
+	unhealthy := 0
+	for _, snap := range items {
+		p.lock.RLock()
+		if p.closed.Load() {
+			p.lock.RUnlock()
+			return
+		}
+		it, ok := p.idle.remove(snap.item)
+		p.lock.RUnlock()
+		if !ok {
+			continue
+		}
+		if !p.healthCheck(it.item) {
+			p.closeItem(it.item, ReasonInvalid)
+			unhealthy++
+			continue
+		}
+		if reason := p.store(it); reason != reasonNone {
+			p.closeItem(it.item, reason)
+		}
+	}
+
+	p.lock.RLock()
+	need := max(unhealthy, min(p.minIdle, p.maxIdle)-p.idle.len())
+	p.lock.RUnlock()
+	p.topUp(need)
+}
+
+// topUp creates up to n new idle items without exceeding maxIdle.
+func (p *Pool) topUp(n int) {
+	for ; n > 0; n-- {
+		item, err := p.create(context.Background())
+		if err != nil {
+			return
+		}
+		if !p.install(item) {
+			// filled up or closed in the meantime
+			return
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package pool
+
+// CloseReason tells why the pool closed an item.
+type CloseReason int
+
+// reasonNone is returned internally when the item is not closed.
+const reasonNone CloseReason = -1
+
+const (
+	ReasonPoolFull CloseReason = iota // no room in the pool
+	ReasonShutdown                    // the pool is closed
+	ReasonCleared                     // the pool is cleared
+	ReasonExpired                     // exceeded max idle time or max lifetime
+	ReasonInvalid                     // failed validation
+)
+
+func (r CloseReason) String() string {
+	switch r {
+	case ReasonPoolFull:
+		return "pool full"
+	case ReasonShutdown:
+		return "shutdown"
+	case ReasonCleared:
+		return "cleared"
+	case ReasonExpired:
+		return "expired"
+	case ReasonInvalid:
+		return "invalid"
+	}
+	return "unknown"
+}
@@ -0,0 +1,34 @@
+package pool
+
+import "context"
+
+// startRefill starts refilling the pool in the background if it holds fewer
+// than lowWater idle items. Only one refill runs at a time.
+func (p *Pool) startRefill() {
+	if p.lowWater <= 0 || p.idle.len() >= p.lowWater {
+		return
+	}
+	if p.refilling.CompareAndSwap(false, true) {
+		go p.refill()
+	}
+}
+
+// refill creates items until the pool holds lowWater idle items. It stops when
+// the pool is closed or full, or when the factory fails.
+func (p *Pool) refill() {
+	defer p.refilling.Store(false)
+	for {
+		p.lock.RLock()
+		idle := p.idle.len()
+		stop := p.closed.Load() || idle >= min(p.lowWater, p.maxIdle) ||
+			p.active.len()+idle >= p.maxActive
+		p.lock.RUnlock()
+		if stop {
+			return
+		}
+		item, err := p.create(context.Background())
+		if err != nil || !p.install(item) {
+			return
+		}
+	}
+}
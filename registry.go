@@ -0,0 +1,59 @@
+package pool
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Registry holds named pools, for example one per backend. It is safe for
+// concurrent use. The zero value is an empty registry ready to use.
+type Registry struct {
+	mu    sync.Mutex
+	pools map[string]*Pool
+}
+
+// GetOrCreate return the pool registered under name, or creates it with
+// NewWithOptions and registers it. factory and opts are only used when the
+// pool is created.
+func (r *Registry) GetOrCreate(name string, factory func() (io.Closer, error), opts ...Option) (*Pool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if p, ok := r.pools[name]; ok {
+		return p, nil
+	}
+	p, err := NewWithOptions(factory, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if r.pools == nil {
+		r.pools = make(map[string]*Pool)
+	}
+	r.pools[name] = p
+	return p, nil
+}
+
+// Lookup return the pool registered under name.
+func (r *Registry) Lookup(name string) (*Pool, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.pools[name]
+	return p, ok
+}
+
+// CloseAll closes all the registered pools and empties the registry. The errors
+// from the pools are joined with errors.Join.
+func (r *Registry) CloseAll() error {
+	r.mu.Lock()
+	pools := r.pools
+	r.pools = nil
+	r.mu.Unlock()
+
+	var errs []error
+	for _, p := range pools {
+		if err := p.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
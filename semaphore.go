@@ -0,0 +1,253 @@
+package pool
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// semaphore counts the active slots of the pool. Waiters are served by
+// priority, and in arrival order among the same priority. Unlike goroutines
+// blocked on a channel send, which are woken in no particular order, a waiter
+// cannot be overtaken indefinitely, which bounds the tail latency of Get.
+type semaphore struct {
+	mu      sync.Mutex
+	size    int
+	used    int
+	count   atomic.Int64 // used, readable without mu
+	seq     uint64
+	waiters waiterQueue
+	limit   int // maximum number of waiters, 0 if unlimited
+	closed  bool
+	freed   chan struct{} // closed when a slot is free, nil if nobody waits
+	empty   chan struct{} // closed when no slot is used, nil if nobody waits
+}
+
+type waiter struct {
+	n     int
+	prio  int
+	seq   uint64
+	index int
+	err   error
+	ready chan struct{} // closed when the slots are granted or the pool closes
+}
+
+func newSemaphore(size int) *semaphore {
+	return &semaphore{size: size}
+}
+
+// acquire reserves n slots, waiting until they are free or the context is
+// done. Higher prio waiters are served first. It fails right away if limit
+// waiters are already waiting.
+func (s *semaphore) acquire(ctx context.Context, n, prio int) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrPoolClosed
+	}
+	if len(s.waiters) == 0 && s.used+n <= s.size {
+		s.add(n)
+		s.mu.Unlock()
+		return nil
+	}
+	if s.limit > 0 && len(s.waiters) >= s.limit {
+		s.mu.Unlock()
+		return ErrTooManyWaiters
+	}
+	s.seq++
+	w := &waiter{n: n, prio: prio, seq: s.seq, ready: make(chan struct{})}
+	heap.Push(&s.waiters, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return w.err
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-w.ready:
+			// granted after the context is done, give the slots back
+			if w.err == nil {
+				s.add(-n)
+				s.notify()
+			}
+		default:
+			heap.Remove(&s.waiters, w.index)
+			// the waiter may have blocked the ones behind it
+			s.notify()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// tryAcquire reserves n slots if they are free without waiting.
+func (s *semaphore) tryAcquire(n int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || len(s.waiters) > 0 || s.used+n > s.size {
+		return false
+	}
+	s.add(n)
+	return true
+}
+
+// release gives back n slots.
+func (s *semaphore) release(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(-min(n, s.used))
+	s.notify()
+}
+
+// resize changes the number of slots. If more slots than size are in use, new
+// waiters are served once enough of them are released.
+func (s *semaphore) resize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size = size
+	s.notify()
+}
+
+// reset frees all slots in use.
+func (s *semaphore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.add(-s.used)
+	s.notify()
+}
+
+// close wakes up all waiters with ErrPoolClosed.
+func (s *semaphore) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for len(s.waiters) > 0 {
+		w := heap.Pop(&s.waiters).(*waiter)
+		w.err = ErrPoolClosed
+		close(w.ready)
+	}
+}
+
+// hasRoom reports whether a slot is free and nobody is waiting.
+func (s *semaphore) hasRoom() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiters) == 0 && s.used < s.size
+}
+
+func (s *semaphore) cap() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// available return the slots tryAcquire could take now, none if callers wait.
+func (s *semaphore) available() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed || len(s.waiters) > 0 {
+		return 0
+	}
+	return max(s.size-s.used, 0)
+}
+
+// usage return the slots in use and the size at the same time.
+func (s *semaphore) usage() (used, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used, s.size
+}
+
+// len return the slots in use. It does not wait for mu.
+func (s *semaphore) len() int {
+	return int(s.count.Load())
+}
+
+// add changes the slots in use by n, must be called with mu held.
+func (s *semaphore) add(n int) {
+	s.used += n
+	s.count.Store(int64(s.used))
+}
+
+// notify grants slots to the waiters in order, must be called with mu held.
+// It stops at the first waiter which does not fit so that it is not starved.
+func (s *semaphore) notify() {
+	for len(s.waiters) > 0 {
+		w := s.waiters[0]
+		if s.used+w.n > s.size {
+			break
+		}
+		heap.Pop(&s.waiters)
+		s.add(w.n)
+		close(w.ready)
+	}
+	if s.freed != nil && s.used < s.size {
+		close(s.freed)
+		s.freed = nil
+	}
+	if s.empty != nil && s.used == 0 {
+		close(s.empty)
+		s.empty = nil
+	}
+}
+
+// emptied return a channel which is closed by the next release leaving no slot
+// in use. The caller checks the slots after getting it, so that the last
+// release is not missed.
+func (s *semaphore) emptied() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.empty == nil {
+		s.empty = make(chan struct{})
+	}
+	return s.empty
+}
+
+// free reports whether a slot is free, otherwise it return a channel which is
+// closed when one may be.
+func (s *semaphore) free() (bool, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used < s.size {
+		return true, nil
+	}
+	if s.freed == nil {
+		s.freed = make(chan struct{})
+	}
+	return false, s.freed
+}
+
+// waiterQueue is a heap of waiters ordered by priority and arrival.
+type waiterQueue []*waiter
+
+func (q waiterQueue) Len() int { return len(q) }
+
+func (q waiterQueue) Less(i, j int) bool {
+	if q[i].prio != q[j].prio {
+		return q[i].prio > q[j].prio
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q waiterQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waiterQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waiterQueue) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return w
+}
@@ -0,0 +1,161 @@
+package pool
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the pool state. Active and Idle are instantaneous
+// values, the other counters are totals since the pool was created.
+type Stats struct {
+	Active    int // items currently checked out
+	Idle      int // items currently in the pool
+	MaxActive int
+	MaxIdle   int
+
+	Created  int64 // items created by the factory
+	Closed   int64 // items closed by the pool
+	Gets     int64 // successful Get and TryGet calls
+	Puts     int64
+	Releases int64
+
+	// OverflowClosed counts the items closed on Put because the pool already
+	// had maxIdle idle items. A high value means maxIdle is too low for the
+	// load and items are created and closed over and over.
+	OverflowClosed int64
+	// SlowCloses counts the items which did not close within the timeout given
+	// to WithCloseTimeout, or before the context of CloseContext was done.
+	SlowCloses int64
+
+	WaitCount    int64         // Get calls which had to wait for an active slot
+	WaitDuration time.Duration // total time spent waiting for an active slot
+
+	Breaker             BreakerState // whether the factory is backed off
+	CreationFailureRate float64      // see Pool.CreationFailureRate
+}
+
+// counters are the cumulative part of Stats. They are atomic so that counting
+// does not add a lock to Get and Put.
+type counters struct {
+	created  atomic.Int64
+	closed   atomic.Int64
+	gets     atomic.Int64
+	puts     atomic.Int64
+	releases atomic.Int64
+
+	overflowClosed atomic.Int64
+	slowCloses     atomic.Int64
+
+	waitCount    atomic.Int64
+	waitDuration atomic.Int64 // nanoseconds
+}
+
+// Stats return a snapshot of the pool state. It does not take the pool lock,
+// so the counters are read one at a time and may be slightly out of step with
+// each other.
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Active:    p.ActiveNum(),
+		Idle:      p.idle.len(),
+		MaxActive: p.MaxActive(),
+		MaxIdle:   p.MaxIdle(),
+		Created:   p.counters.created.Load(),
+		Closed:    p.counters.closed.Load(),
+		Gets:      p.counters.gets.Load(),
+		Puts:      p.counters.puts.Load(),
+		Releases:  p.counters.releases.Load(),
+
+		OverflowClosed: p.counters.overflowClosed.Load(),
+		SlowCloses:     p.counters.slowCloses.Load(),
+
+		WaitCount:    p.counters.waitCount.Load(),
+		WaitDuration: time.Duration(p.counters.waitDuration.Load()),
+
+		Breaker:             p.breaker.current(),
+		CreationFailureRate: p.failures.rate(),
+	}
+}
+
+// create calls the factory, counts the created item and reports it to the
+// OnCreate hook. The factory is not called while it is backed off.
+func (p *Pool) create(ctx context.Context) (io.Closer, error) {
+	if err := p.breaker.allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	item, err := callFactory(ctx, p.new)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.breaker.done(false)
+			p.failures.record(false)
+		} else {
+			p.breaker.abort()
+		}
+		return nil, err
+	}
+	p.breaker.done(true)
+	p.failures.record(true)
+	p.counters.created.Add(1)
+	if p.onCreate != nil {
+		p.onCreate(item, time.Since(start))
+	}
+	return item, nil
+}
+
+// callFactory calls factory. A panic in the factory is returned as an error,
+// and so is a nil item.
+func callFactory(ctx context.Context, factory func(context.Context) (io.Closer, error)) (item io.Closer, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			item, err = nil, fmt.Errorf("pool: factory panicked: %v", r)
+		}
+	}()
+	item, err = factory(ctx)
+	if err == nil && item == nil {
+		err = ErrNilItem
+	}
+	return item, err
+}
+
+// overflow closes an item returned to a full pool.
+func (p *Pool) overflow(item io.Closer) {
+	p.counters.overflowClosed.Add(1)
+	_ = p.closeItem(item, ReasonPoolFull)
+}
+
+// closeItem closes an item owned by the pool, counts it and reports it to the
+// OnClose hook. It return the error from the item Close.
+func (p *Pool) closeItem(item io.Closer, reason CloseReason) error {
+	err := p.closeWithTimeout(item)
+	p.counters.closed.Add(1)
+	if p.onClose != nil {
+		p.onClose(item, reason)
+	}
+	p.meta.set(item, nil)
+	return err
+}
+
+// closeWithTimeout closes item, giving up waiting after closeTimeout. The
+// goroutine running Close exits whenever Close returns. The error of a slow
+// close is lost.
+func (p *Pool) closeWithTimeout(item io.Closer) error {
+	if p.closeTimeout <= 0 {
+		return item.Close()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- item.Close()
+	}()
+	timer := time.NewTimer(p.closeTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		p.counters.slowCloses.Add(1)
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package pool
+
+// PoolStatus tells what a Get would do right now, see Pool.Probe.
+type PoolStatus int
+
+const (
+	StatusIdleAvailable PoolStatus = iota // an idle item would be handed out
+	StatusCanCreate                       // an active slot is free but the factory would be called
+	StatusSaturated                       // the caller would wait for an active slot
+	StatusClosed                          // the pool is closed
+)
+
+func (s PoolStatus) String() string {
+	switch s {
+	case StatusIdleAvailable:
+		return "idle available"
+	case StatusCanCreate:
+		return "can create"
+	case StatusSaturated:
+		return "saturated"
+	case StatusClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// Probe reports without blocking whether a Get would take an idle item, create
+// one or wait, so that a fast path can decide whether the cost is acceptable.
+// Like Available, it may be stale by the time it is read. Idle items may still
+// be closed by Get if they expired or fail validation.
+func (p *Pool) Probe() PoolStatus {
+	if p.IsClosed() {
+		return StatusClosed
+	}
+	if p.Available() == 0 {
+		return StatusSaturated
+	}
+	if p.idle.len() > 0 {
+		return StatusIdleAvailable
+	}
+	return StatusCanCreate
+}
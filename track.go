@@ -0,0 +1,207 @@
+package pool
+
+import (
+	"io"
+	"reflect"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// borrow is the bookkeeping of a checked out item.
+type borrow struct {
+	created time.Time
+	usage   int
+	weight  int
+	since   time.Time
+	stack   string
+}
+
+// LeakInfo describes an item checked out for longer than the leak threshold.
+type LeakInfo struct {
+	Item  io.Closer
+	Since time.Time // when the item was checked out
+	Stack string    // stack trace of the Get call
+}
+
+// tracking reports whether checked out items need to be tracked.
+func (p *Pool) tracking() bool {
+	return p.maxLifetime.Load() > 0 || p.maxUsage > 0 || p.weight != nil || p.leakThreshold > 0 ||
+		p.ownerCheck || p.createdOrder
+}
+
+// identifiable reports whether item can be told apart from other items, that is
+// whether it can be compared and used as a map key. Items of other types, like
+// slices or maps, are not tracked, have no metadata and are not found in the
+// idle items, so that they do not make the pool panic.
+func identifiable(item io.Closer) bool {
+	return item != nil && reflect.TypeOf(item).Comparable()
+}
+
+// track records a checked out item so that its creation time and usage
+// survive the round trip through the caller.
+func (p *Pool) track(it idleItem) {
+	if !p.tracking() || !identifiable(it.item) {
+		return
+	}
+	b := &borrow{created: it.created, usage: it.usage, weight: it.weight, since: time.Now()}
+	if p.leakThreshold > 0 {
+		b.stack = string(debug.Stack())
+	}
+	p.borrowedLock.Lock()
+	defer p.borrowedLock.Unlock()
+	if p.leakThreshold <= 0 && !p.ownerCheck && len(p.borrowed) > 2*p.active.cap() {
+		p.pruneBorrowed()
+	}
+	p.borrowed[it.item] = b
+}
+
+// pruneBorrowed drops the oldest entries over the number of active items, which
+// are left behind by Release. Must be called with borrowedLock held.
+func (p *Pool) pruneBorrowed() {
+	n := len(p.borrowed) - p.active.len()
+	if n <= 0 {
+		return
+	}
+	items := make([]io.Closer, 0, len(p.borrowed))
+	for item := range p.borrowed {
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return p.borrowed[items[i]].since.Before(p.borrowed[items[j]].since)
+	})
+	for _, item := range items[:n] {
+		delete(p.borrowed, item)
+	}
+}
+
+// untrack return a checked out item with its bookkeeping. Items that are not
+// tracked are considered newly created.
+func (p *Pool) untrack(item io.Closer) idleItem {
+	now := time.Now()
+	it := idleItem{item: item, created: now, returned: now}
+	if !p.tracking() || !identifiable(item) {
+		it.weight = p.weightOf(item)
+		return it
+	}
+	p.borrowedLock.Lock()
+	defer p.borrowedLock.Unlock()
+	if b, ok := p.borrowed[item]; ok {
+		delete(p.borrowed, item)
+		it.created = b.created
+		it.usage = b.usage
+		it.weight = b.weight
+	} else {
+		it.weight = p.weightOf(item)
+	}
+	return it
+}
+
+// ReleaseItem is like Release, and forgets the item for leak detection. With
+// WithWeight, it gives back all the slots taken by the item. It return the same
+// errors as Release, and ErrNotOwned with WithOwnerCheck if the item does not
+// come from the pool.
+func (p *Pool) ReleaseItem(item io.Closer) error {
+	if item == nil {
+		return ErrNilItem
+	}
+	if p.foreign(item) {
+		return ErrNotOwned
+	}
+	p.meta.set(item, nil)
+	return p.release(p.untrack(item).weight)
+}
+
+// Owns reports whether item is idle in the pool or checked out from it.
+// Checked out items are only known reliably with WithOwnerCheck. Items which
+// are not comparable are never owned.
+func (p *Pool) Owns(item io.Closer) bool {
+	if !identifiable(item) {
+		return false
+	}
+	if p.idle.has(item) {
+		return true
+	}
+	p.borrowedLock.Lock()
+	defer p.borrowedLock.Unlock()
+	_, ok := p.borrowed[item]
+	return ok
+}
+
+// foreign reports whether item is known not to come from the pool, which is
+// only checked with WithOwnerCheck, and for items which are comparable.
+func (p *Pool) foreign(item io.Closer) bool {
+	return p.ownerCheck && identifiable(item) && !p.Owns(item)
+}
+
+// LeakedItems return the items checked out for longer than the threshold given
+// to WithLeakDetection. It return nil if leak detection is disabled.
+func (p *Pool) LeakedItems() []LeakInfo {
+	if p.leakThreshold <= 0 {
+		return nil
+	}
+	p.borrowedLock.Lock()
+	defer p.borrowedLock.Unlock()
+	var leaks []LeakInfo
+	for item, b := range p.borrowed {
+		if time.Since(b.since) > p.leakThreshold {
+			leaks = append(leaks, LeakInfo{Item: item, Since: b.since, Stack: b.stack})
+		}
+	}
+	return leaks
+}
+
+// closedSet remembers the last items closed when put back, up to size, so that
+// putting one of them back again does not free the slot of another caller.
+type closedSet struct {
+	mu   sync.Mutex
+	size int
+	m    map[io.Closer]int // index in ring
+	ring []io.Closer
+	next int
+	n    atomic.Int64 // len(m), readable without mu
+}
+
+func (s *closedSet) add(item io.Closer) {
+	if s.size <= 0 || !identifiable(item) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.m == nil {
+		s.m = make(map[io.Closer]int, s.size)
+		s.ring = make([]io.Closer, s.size)
+	}
+	if old := s.ring[s.next]; old != nil && s.m[old] == s.next {
+		delete(s.m, old)
+	}
+	s.ring[s.next] = item
+	s.m[item] = s.next
+	s.next = (s.next + 1) % s.size
+	s.n.Store(int64(len(s.m)))
+}
+
+func (s *closedSet) has(item io.Closer) bool {
+	if s.n.Load() == 0 || !identifiable(item) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.m[item]
+	return ok
+}
+
+func (s *closedSet) remove(item io.Closer) {
+	if s.n.Load() == 0 || !identifiable(item) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i, ok := s.m[item]; ok {
+		delete(s.m, item)
+		s.ring[i] = nil
+		s.n.Store(int64(len(s.m)))
+	}
+}
@@ -0,0 +1,119 @@
+package pool
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Typed is a type-safe wrapper of Pool holding items of type T.
+type Typed[T io.Closer] struct {
+	p *Pool
+}
+
+// NewTyped create a new typed pool. See New for the meaning of the parameters.
+func NewTyped[T io.Closer](factory func() (T, error), maxActive, maxIdle int) (*Typed[T], error) {
+	p, err := New(func() (io.Closer, error) {
+		return factory()
+	}, maxActive, maxIdle)
+	if err != nil {
+		return nil, err
+	}
+	return &Typed[T]{p: p}, nil
+}
+
+// Pool return the underlying untyped pool.
+func (t *Typed[T]) Pool() *Pool {
+	return t.p
+}
+
+// Get return an item from the pool. See Pool.Get.
+func (t *Typed[T]) Get(ctx context.Context) (T, error) {
+	return typed[T](t.p.Get(ctx))
+}
+
+// GetPriority return an item from the pool. See Pool.GetPriority.
+func (t *Typed[T]) GetPriority(ctx context.Context, prio int) (T, error) {
+	return typed[T](t.p.GetPriority(ctx, prio))
+}
+
+// GetFresh return a new item. See Pool.GetFresh.
+func (t *Typed[T]) GetFresh(ctx context.Context) (T, error) {
+	return typed[T](t.p.GetFresh(ctx))
+}
+
+// GetWithTimeout return an item from the pool. See Pool.GetWithTimeout.
+func (t *Typed[T]) GetWithTimeout(d time.Duration) (T, error) {
+	return typed[T](t.p.GetWithTimeout(d))
+}
+
+// TryGet return an item from the pool without blocking. See Pool.TryGet.
+func (t *Typed[T]) TryGet() (T, error) {
+	return typed[T](t.p.TryGet())
+}
+
+// Put add back item in the pool. See Pool.Put.
+func (t *Typed[T]) Put(item T) {
+	t.p.Put(item)
+}
+
+// TryPut add back item in the pool and reports whether it was kept. See
+// Pool.TryPut.
+func (t *Typed[T]) TryPut(item T) bool {
+	return t.p.TryPut(item)
+}
+
+// Release the item without put it back in the pool. See Pool.Release.
+func (t *Typed[T]) Release() error {
+	return t.p.Release()
+}
+
+// Discard closes item and frees its slot. See Pool.Discard.
+func (t *Typed[T]) Discard(item T) error {
+	return t.p.Discard(item)
+}
+
+// Use calls fn with an item of the pool. See Pool.Use.
+func (t *Typed[T]) Use(ctx context.Context, fn func(T) error) error {
+	return t.p.Use(ctx, func(item io.Closer) error {
+		return fn(item.(T))
+	})
+}
+
+// Close the pool and all the items in it. See Pool.Close.
+func (t *Typed[T]) Close() error {
+	return t.p.Close()
+}
+
+// IsClosed return true if the pool is closed and false otherwise.
+func (t *Typed[T]) IsClosed() bool {
+	return t.p.IsClosed()
+}
+
+// Fill the pool to maxIdle.
+func (t *Typed[T]) Fill() {
+	t.p.Fill()
+}
+
+// Clear all items in the pool. See Pool.Clear.
+func (t *Typed[T]) Clear() error {
+	return t.p.Clear()
+}
+
+// IdleNum return numbers of idle items in the pool.
+func (t *Typed[T]) IdleNum() int {
+	return t.p.IdleNum()
+}
+
+// ActiveNum return numbers of items currently checked out from the pool.
+func (t *Typed[T]) ActiveNum() int {
+	return t.p.ActiveNum()
+}
+
+func typed[T io.Closer](item io.Closer, err error) (T, error) {
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return item.(T), nil
+}
@@ -0,0 +1,38 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Use gets an item, calls fn with it and gives it back, and return the error
+// of Get or fn. The item is put back if fn return nil. If fn return an error,
+// the item is discarded, unless the pool is created WithKeepOnError, in which
+// case it is put back as well. If fn panics, the item is discarded and the
+// panic goes on. The error from closing the discarded item is joined to the
+// one of fn.
+func (p *Pool) Use(ctx context.Context, fn func(io.Closer) error) error {
+	item, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	done := false
+	defer func() {
+		if !done {
+			// fn panicked, the item may be in any state
+			_ = p.Discard(item)
+		}
+	}()
+	err = fn(item)
+	done = true
+
+	if err == nil || p.keepOnError {
+		p.Put(item)
+		return err
+	}
+	if derr := p.Discard(item); derr != nil {
+		return errors.Join(err, derr)
+	}
+	return err
+}
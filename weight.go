@@ -0,0 +1,43 @@
+package pool
+
+import (
+	"context"
+	"io"
+)
+
+// weightOf return the number of active slots an item takes.
+func (p *Pool) weightOf(item io.Closer) int {
+	if p.weight == nil {
+		return 1
+	}
+	return min(max(p.weight(item), 1), p.active.cap())
+}
+
+// reserveWeight reserves the slots taken by an item on top of the one reserved
+// by acquire, waiting until they are free or the context is done.
+func (p *Pool) reserveWeight(ctx context.Context, prio int, it *idleItem) error {
+	if p.tryReserveWeight(it) {
+		return nil
+	}
+	// wait for all of them at once rather than holding one, so that
+	// heavy items cannot deadlock each other
+	p.releaseSlot()
+	return p.active.acquire(ctx, it.weight, prio)
+}
+
+// tryReserveWeight reserves the slots taken by an item on top of the one
+// reserved by acquire if they are free.
+func (p *Pool) tryReserveWeight(it *idleItem) bool {
+	it.weight = p.weightOf(it.item)
+	return it.weight == 1 || p.active.tryAcquire(it.weight-1)
+}
+
+// requeue puts back an item which could not be handed out, without touching
+// the active slots.
+func (p *Pool) requeue(it idleItem) {
+	it.usage--
+	it.weight = 0
+	if reason := p.store(it); reason != reasonNone {
+		p.closeItem(it.item, reason)
+	}
+}